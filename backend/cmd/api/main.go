@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -41,12 +44,18 @@ func main() {
 				strings.HasSuffix(origin, os.Getenv("FRONTEND_PREVIEW_SUFFIX")) ||
 				origin == os.Getenv("FRONTEND_DEV_ORIGIN"))
 		},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "Origin"},
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"Authorization", "Content-Type", "Origin", "Prefer",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Defer-Length"},
+		ExposeHeaders: []string{"Location", "Preference-Applied", "Retry-After",
+			"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length",
+			"Upload-Metadata", "Upload-Expires"},
 		AllowCredentials: true,
 	}))
 
 	r.SetTrustedProxies(nil)
+	// larger multipart files are spooled to disk, so parallel uploads do not all sit in memory
+	r.MaxMultipartMemory = 8 << 20
 
 	r.Static("/public", "./public")
 	r.StaticFile("/", "./public/index.html")
@@ -54,23 +63,155 @@ func main() {
 	DB := database.InitDB("shutterdev.db")
 	defer DB.Close()
 
-	R2Service, r2Err := services.NewR2Service(
-		os.Getenv("R2_ACCOUNT_ID"),
-		os.Getenv("R2_ACCESS_KEY_ID"),
-		os.Getenv("R2_SECRET_ACCESS_KEY"),
-		os.Getenv("R2_BUCKET_PUBLIC_URL"),
-		os.Getenv("R2_BUCKET_NAME"),
-	)
-	if r2Err != nil {
-		log.Println("[ERROR] Could not initialize R2 Service", r2Err)
+	storage, storageErr := initStorage(r)
+	if storageErr != nil {
+		log.Fatal("[FATAL] Could not initialize storage backend - ", storageErr)
 	}
 
+	exif.RegisterParsers(services.OffsetTimeParser)
 	exif.RegisterParsers(mknote.All...)
 
-	photoHandler := handlers.NewPhotoHandler(DB, R2Service)
+	photoHandler := handlers.NewPhotoHandler(DB, storage)
+
+	renditions, err := services.ParseRenditionSpecs(os.Getenv("RENDITIONS"))
+	if err != nil {
+		log.Fatal("[FATAL] Invalid RENDITIONS - ", err)
+	}
+	fallbacks, err := services.ParseFallbackFormats(os.Getenv("RENDITION_FALLBACKS"))
+	if err != nil {
+		log.Fatal("[FATAL] Invalid RENDITION_FALLBACKS - ", err)
+	}
+	photoHandler.Renditions = services.WithFallbacks(renditions, fallbacks)
+	if !services.HasEncoder(services.FormatAVIF) {
+		log.Println("[RENDITIONS] No AVIF encoder built in, serving WebP and JPEG only")
+	}
+
+	uploadPolicy := services.DefaultUploadPolicy
+	uploadPolicy.MaxBytes = int64(envInt("UPLOAD_MAX_MB", int(uploadPolicy.MaxBytes>>20), 0)) << 20
+	uploadPolicy.MinDimension = envInt("UPLOAD_MIN_DIMENSION", uploadPolicy.MinDimension, 0)
+	uploadPolicy.MaxDimension = envInt("UPLOAD_MAX_DIMENSION", uploadPolicy.MaxDimension, 0)
+	uploadPolicy.MaxPixels = int64(envFloat("UPLOAD_MAX_MEGAPIXELS", float64(uploadPolicy.MaxPixels)/1e6) * 1e6)
+	uploadPolicy.MaxAspectRatio = envFloat("UPLOAD_MAX_ASPECT_RATIO", uploadPolicy.MaxAspectRatio)
+	if uploadPolicy.MaxAspectRatio > 0 && uploadPolicy.MaxAspectRatio < 1 {
+		log.Fatal("[FATAL] UPLOAD_MAX_ASPECT_RATIO is long side over short side and must be at least 1")
+	}
+	if uploadPolicy.AllowedTypes, err = services.ParseAllowedTypes(os.Getenv("UPLOAD_ALLOWED_TYPES")); err != nil {
+		log.Fatal("[FATAL] Invalid UPLOAD_ALLOWED_TYPES - ", err)
+	}
+	photoHandler.UploadPolicy = uploadPolicy
+	photoHandler.MaxBatchFiles = envInt("UPLOAD_BATCH_MAX_FILES", photoHandler.MaxBatchFiles, 1)
+	if spoolDir := os.Getenv("UPLOAD_SPOOL_DIR"); spoolDir != "" {
+		photoHandler.SpoolDir = spoolDir
+	}
+	photoHandler.JobRetention = envDuration("UPLOAD_JOB_RETENTION", photoHandler.JobRetention)
+	photoHandler.TusExpiry = envDuration("UPLOAD_TUS_EXPIRY", photoHandler.TusExpiry)
+
+	poolConfig := services.DefaultPoolConfig()
+	poolConfig.Concurrency = envInt("PROCESSING_CONCURRENCY", poolConfig.Concurrency, 1)
+	poolConfig.MemoryBudget = int64(envInt("PROCESSING_MEMORY_MB", int(poolConfig.MemoryBudget>>20), 0)) << 20
+	poolConfig.QueueSize = envInt("PROCESSING_QUEUE_SIZE", poolConfig.QueueSize, 0)
+	poolConfig.QueueTimeout = envDuration("PROCESSING_QUEUE_TIMEOUT", poolConfig.QueueTimeout)
+	photoHandler.Pool = services.NewProcessingPool(poolConfig)
+	log.Printf("[PROCESSING] %d at a time within %d MB, %d may wait", poolConfig.Concurrency, poolConfig.MemoryBudget>>20, poolConfig.QueueSize)
+
+	duplicates, err := handlers.ParseDuplicatePolicy(os.Getenv("DUPLICATE_POLICY"))
+	if err != nil {
+		log.Fatal("[FATAL] Invalid DUPLICATE_POLICY - ", err)
+	}
+	photoHandler.Duplicates = duplicates
+	if threshold := os.Getenv("DUPLICATE_THRESHOLD"); threshold != "" {
+		distance, err := strconv.Atoi(threshold)
+		if err != nil || distance < 0 || distance > 64 {
+			log.Fatal("[FATAL] DUPLICATE_THRESHOLD must be a Hamming distance between 0 and 64")
+		}
+		photoHandler.DuplicateThreshold = distance
+	}
+
+	if retentionDays := os.Getenv("TRASH_RETENTION_DAYS"); retentionDays != "" {
+		days, err := strconv.Atoi(retentionDays)
+		if err != nil || days < 0 {
+			log.Fatal("[FATAL] TRASH_RETENTION_DAYS must be a whole number of days (0 disables the purge)")
+		}
+		photoHandler.TrashRetention = time.Duration(days) * 24 * time.Hour
+	}
+	go photoHandler.RunTrashPurger(context.Background(), envDuration("TRASH_PURGE_INTERVAL", time.Hour))
+
+	photoHandler.DeleteRetry = handlers.DeleteRetryPolicy{
+		BaseDelay:   envDuration("DELETE_RETRY_BASE_DELAY", handlers.DefaultDeleteRetryPolicy.BaseDelay),
+		MaxDelay:    envDuration("DELETE_RETRY_MAX_DELAY", handlers.DefaultDeleteRetryPolicy.MaxDelay),
+		MaxAttempts: handlers.DefaultDeleteRetryPolicy.MaxAttempts,
+	}
+	if maxAttempts := os.Getenv("DELETE_RETRY_MAX_ATTEMPTS"); maxAttempts != "" {
+		attempts, err := strconv.Atoi(maxAttempts)
+		if err != nil || attempts < 0 {
+			log.Fatal("[FATAL] DELETE_RETRY_MAX_ATTEMPTS must be a whole number (0 retries forever)")
+		}
+		photoHandler.DeleteRetry.MaxAttempts = attempts
+	}
+	go photoHandler.RunUploadJobs(context.Background())
+	go photoHandler.RunFailedDeleteRetrier(context.Background(), envDuration("DELETE_RETRY_INTERVAL", time.Minute))
 
 	userApiKey := os.Getenv("ADMIN_SECRET_KEY")
 	handlers.RegisterRoutes(r, photoHandler, userApiKey)
 
 	r.Run()
 }
+
+// initStorage builds the blob backend selected by STORAGE_DRIVER.
+// The local driver also registers a static route so Gin serves the stored files.
+func initStorage(r *gin.Engine) (services.Storage, error) {
+	storage, err := services.NewStorageFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if localStorage, ok := storage.(*services.LocalStorage); ok {
+		mountPath := services.LocalMountPath()
+		r.Static(mountPath, localStorage.RootDir)
+		log.Printf("[STORAGE] Serving local storage under %s", mountPath)
+	}
+	return storage, nil
+}
+
+// envDuration parses a Go duration such as "30m" or "1h", falling back when unset or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("[ERROR] Invalid %s %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// envInt parses a whole number of at least minValue, falling back when unset. Invalid values are fatal.
+func envInt(key string, fallback, minValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < minValue {
+		log.Fatalf("[FATAL] %s must be a whole number of at least %d", key, minValue)
+	}
+	return number
+}
+
+// envFloat parses a non-negative number, falling back when unset. Invalid values are fatal.
+func envFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		log.Fatalf("[FATAL] %s must be a number of at least 0", key)
+	}
+	return number
+}
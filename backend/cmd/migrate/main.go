@@ -0,0 +1,77 @@
+// command line access to the schema migrations, e.g.
+//
+//	go run ./cmd/migrate status
+//	go run ./cmd/migrate -dry-run up
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"shutterdev/backend/internal/database"
+)
+
+func main() {
+	dbPath := flag.String("db", "shutterdev.db", "path to the SQLite database")
+	dryRun := flag.Bool("dry-run", false, "run pending migrations in a transaction that is rolled back")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: migrate [-db path] [-dry-run] <status|up>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	command := flag.Arg(0)
+	if command == "" {
+		command = "status"
+	}
+
+	db, err := database.OpenDB(*dbPath)
+	if err != nil {
+		log.Fatalf("[FATAL] Could not open database %s - %v", *dbPath, err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "status":
+		statusList, err := database.GetMigrationStatus(db)
+		if err != nil {
+			log.Fatalf("[FATAL] Could not read migration status - %v", err)
+		}
+
+		pending := 0
+		for _, status := range statusList {
+			if status.Applied {
+				fmt.Printf("  [applied %s] %3d  %s\n", status.AppliedAt.Local().Format("2006-01-02 15:04:05"), status.Version, status.Description)
+			} else {
+				pending++
+				fmt.Printf("  [pending            ] %3d  %s\n", status.Version, status.Description)
+			}
+		}
+		fmt.Printf("\n%d applied, %d pending\n", len(statusList)-pending, pending)
+
+	case "up":
+		var ran []database.Migration
+		if *dryRun {
+			ran, err = database.MigrateDryRun(db)
+		} else {
+			ran, err = database.Migrate(db)
+		}
+		if err != nil {
+			log.Fatalf("[FATAL] %v", err)
+		}
+
+		if len(ran) == 0 {
+			fmt.Println("Schema is already up to date")
+		} else if *dryRun {
+			fmt.Printf("%d migrations would be applied, nothing was written\n", len(ran))
+		} else {
+			fmt.Printf("Applied %d migrations\n", len(ran))
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,83 @@
+// compare the storage bucket with the photos table from the command line, e.g.
+//
+//	go run ./cmd/reconcile
+//	go run ./cmd/reconcile -fix
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/handlers"
+	"shutterdev/backend/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dbPath := flag.String("db", "shutterdev.db", "path to the SQLite database")
+	fix := flag.Bool("fix", false, "delete orphan objects and move dangling photos to the trash")
+	grace := flag.Duration("grace", handlers.DefaultReconcileGracePeriod, "ignore orphan objects newer than this")
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: reconcile [-db path] [-fix] [-grace 1h] [-json]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := godotenv.Load(".env"); err != nil {
+		log.Println("[ERROR] Could not load .env file", err)
+	}
+
+	db, err := database.OpenDB(*dbPath)
+	if err != nil {
+		log.Fatalf("[FATAL] Could not open database %s - %v", *dbPath, err)
+	}
+	defer db.Close()
+
+	storage, err := services.NewStorageFromEnv()
+	if err != nil {
+		log.Fatalf("[FATAL] Could not initialize storage backend - %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := handlers.NewPhotoHandler(db, storage).ReconcileStorage(ctx, *fix, *grace)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("[FATAL] %v", err)
+		}
+		return
+	}
+
+	for _, orphan := range report.OrphanObjects {
+		fmt.Printf("  [orphan  ] %s (%d bytes, %s)\n", orphan.Key, orphan.Size, orphan.LastModified.Local().Format("2006-01-02 15:04:05"))
+	}
+	for _, dangling := range report.DanglingPhotos {
+		state := "dangling"
+		if dangling.Trashed {
+			state = "trashed "
+		}
+		fmt.Printf("  [%s] photo %s is missing %v\n", state, dangling.ID, dangling.MissingKeys)
+	}
+
+	fmt.Printf("\n%d objects scanned, %d files referenced, %d orphans, %d dangling photos, %d recent objects skipped\n",
+		report.ScannedObjects, report.ReferencedFiles, len(report.OrphanObjects), len(report.DanglingPhotos), report.SkippedRecent)
+	if report.Fixed != nil {
+		fmt.Printf("Deleted %d orphan objects (%d failed), moved %d dangling photos to the trash\n",
+			report.Fixed.DeletedObjects, report.Fixed.FailedObjects, report.Fixed.TrashedPhotos)
+	}
+}
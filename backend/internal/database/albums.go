@@ -0,0 +1,627 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"shutterdev/backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrSlugTaken is returned when an explicitly requested album slug is already in use.
+	ErrSlugTaken = errors.New("another album already uses this slug")
+	// ErrPhotoNotFound is returned when a request references a photo id that does not exist.
+	ErrPhotoNotFound = errors.New("one or more photos do not exist")
+	// ErrPhotoNotInAlbum is returned when the chosen cover photo is not part of the album.
+	ErrPhotoNotInAlbum = errors.New("photo is not part of this album")
+)
+
+type AlbumsResponse struct {
+	Albums     []models.Album `json:"albums"`
+	NextCursor models.Cursor  `json:"nextCursor"`
+	HasMore    bool           `json:"hasMore"`
+}
+
+type AlbumPhotosResponse struct {
+	Album      models.Album            `json:"album"`
+	Photos     []models.ThumbnailPhoto `json:"photos"`
+	NextCursor models.AlbumCursor      `json:"nextCursor"`
+	HasMore    bool                    `json:"hasMore"`
+}
+
+// AlbumUpdate holds the editable fields of an album, nil fields are left untouched.
+// An empty CoverPhotoID clears the cover so the first photo is used again.
+type AlbumUpdate struct {
+	Title        *string
+	Slug         *string
+	Description  *string
+	CoverPhotoID *string
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify lowercases s and collapses everything that is not a letter or digit into single dashes.
+func Slugify(s string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// album columns plus the cover photo, which falls back to the first photo by position.
+// Photos in the trash are neither counted nor used as cover.
+const selectAlbumSQL = `
+	SELECT a.id, a.slug, a.title, a.description, a.cover_photo_id, a.created_at, a.updated_at,
+		(
+			SELECT COUNT(*) FROM album_photos ap
+			INNER JOIN photos p ON p.id = ap.photo_id
+			WHERE ap.album_id = a.id AND p.deleted_at IS NULL
+		) AS photo_count,
+		cp.id, cp.thumbnail_key, cp.thumbnail_width, cp.thumbnail_height, cp.blur_hash, cp.dominant_color, cp.created_at
+	FROM albums a
+	LEFT JOIN photos cp ON cp.id = COALESCE(
+		(SELECT p.id FROM photos p WHERE p.id = a.cover_photo_id AND p.deleted_at IS NULL),
+		(
+			SELECT ap.photo_id FROM album_photos ap
+			INNER JOIN photos p ON p.id = ap.photo_id
+			WHERE ap.album_id = a.id AND p.deleted_at IS NULL
+			ORDER BY ap.position, ap.photo_id LIMIT 1
+		)
+	)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAlbum(row rowScanner) (models.Album, error) {
+	var album models.Album
+	var coverPhotoID, coverID, coverKey, coverBlurHash, coverColor sql.NullString
+	var coverWidth, coverHeight sql.NullInt64
+	var updatedAt, coverCreatedAt sql.NullTime
+
+	err := row.Scan(
+		&album.ID,
+		&album.Slug,
+		&album.Title,
+		&album.Description,
+		&coverPhotoID,
+		&album.CreatedAt,
+		&updatedAt,
+		&album.PhotoCount,
+		&coverID,
+		&coverKey,
+		&coverWidth,
+		&coverHeight,
+		&coverBlurHash,
+		&coverColor,
+		&coverCreatedAt,
+	)
+	if err != nil {
+		return album, err
+	}
+
+	if coverPhotoID.Valid {
+		album.CoverPhotoID = &coverPhotoID.String
+	}
+	if updatedAt.Valid {
+		album.UpdatedAt = &updatedAt.Time
+	}
+	if coverID.Valid {
+		album.CoverPhoto = &models.ThumbnailPhoto{
+			ID:            coverID.String,
+			ThumbnailKey:  coverKey.String,
+			ThumbWidth:    int(coverWidth.Int64),
+			ThumbHeight:   int(coverHeight.Int64),
+			BlurHash:      coverBlurHash.String,
+			DominantColor: coverColor.String,
+			CreatedAt:     coverCreatedAt.Time,
+		}
+	}
+
+	return album, nil
+}
+
+// CreateAlbum inserts a new album and returns its id. When album.Slug is empty it is derived
+// from the title and suffixed with -2, -3, ... until it is unique. An explicit slug that is
+// already taken returns ErrSlugTaken.
+func CreateAlbum(db *sql.DB, ctx context.Context, album *models.Album) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	slug := Slugify(album.Slug)
+	if slug != "" {
+		taken, err := slugTaken(tx, ctx, slug, "")
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", ErrSlugTaken
+		}
+	} else {
+		baseSlug := Slugify(album.Title)
+		if baseSlug == "" {
+			baseSlug = "album"
+		}
+		slug = baseSlug
+		for i := 2; ; i++ {
+			taken, err := slugTaken(tx, ctx, slug, "")
+			if err != nil {
+				return "", err
+			}
+			if !taken {
+				break
+			}
+			slug = fmt.Sprintf("%s-%d", baseSlug, i)
+		}
+	}
+
+	id := uuid.New().String()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO albums (id, slug, title, description, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		id, slug, album.Title, album.Description, time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	album.ID = id
+	album.Slug = slug
+	return id, nil
+}
+
+// GetAlbumByID returns the album or nil if it does not exist.
+func GetAlbumByID(db *sql.DB, ctx context.Context, id string) (*models.Album, error) {
+	album, err := scanAlbum(db.QueryRowContext(ctx, selectAlbumSQL+` WHERE a.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	albums := []models.Album{album}
+	if err := attachCoverRenditions(db, ctx, albums); err != nil {
+		return nil, err
+	}
+	return &albums[0], nil
+}
+
+// GetAllAlbums lists albums newest first using the same (created_at, id) keyset cursor as GetAllPhotos.
+func GetAllAlbums(db *sql.DB, ctx context.Context, createdAt time.Time, id string, LIMIT int) (AlbumsResponse, error) {
+	var response AlbumsResponse
+
+	query := selectAlbumSQL
+	var args []any
+	if !createdAt.IsZero() {
+		query += ` WHERE (a.created_at < ?) OR (a.created_at = ? AND a.id < ?)`
+		args = append(args, createdAt, createdAt, id)
+	}
+	query += ` ORDER BY a.created_at DESC, a.id DESC LIMIT ?`
+	args = append(args, LIMIT)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return AlbumsResponse{}, err
+	}
+	defer rows.Close()
+
+	albums := make([]models.Album, 0, LIMIT)
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return AlbumsResponse{}, err
+		}
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		return AlbumsResponse{}, err
+	}
+	rows.Close()
+
+	if err := attachCoverRenditions(db, ctx, albums); err != nil {
+		return AlbumsResponse{}, err
+	}
+
+	response.Albums = albums
+	if len(albums) > 0 {
+		last := albums[len(albums)-1]
+		response.NextCursor = models.Cursor{
+			ID:        last.ID,
+			CreatedAt: last.CreatedAt,
+		}
+	}
+	response.HasMore = (len(albums) == LIMIT)
+
+	return response, nil
+}
+
+// GetAlbumPhotosBySlug returns the album and one page of its photos in manual order.
+// Pass a nil cursor for the first page. It returns nil if no album has the slug.
+func GetAlbumPhotosBySlug(db *sql.DB, ctx context.Context, slug string, cursor *models.AlbumCursor, LIMIT int) (*AlbumPhotosResponse, error) {
+	album, err := scanAlbum(db.QueryRowContext(ctx, selectAlbumSQL+` WHERE a.slug = ?`, slug))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT p.id, p.thumbnail_key, p.thumbnail_width, p.thumbnail_height, p.blur_hash, p.dominant_color, p.created_at, ap.position
+		FROM album_photos ap
+		INNER JOIN photos p ON p.id = ap.photo_id
+		WHERE ap.album_id = ? AND p.deleted_at IS NULL`
+	args := []any{album.ID}
+	if cursor != nil {
+		query += ` AND ((ap.position > ?) OR (ap.position = ? AND p.id > ?))`
+		args = append(args, cursor.Position, cursor.Position, cursor.ID)
+	}
+	query += ` ORDER BY ap.position ASC, p.id ASC LIMIT ?`
+	args = append(args, LIMIT)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := &AlbumPhotosResponse{
+		Album:  album,
+		Photos: make([]models.ThumbnailPhoto, 0, LIMIT),
+	}
+	var lastPosition int
+	for rows.Next() {
+		var photoThumbnail models.ThumbnailPhoto
+		err := rows.Scan(
+			&photoThumbnail.ID,
+			&photoThumbnail.ThumbnailKey,
+			&photoThumbnail.ThumbWidth,
+			&photoThumbnail.ThumbHeight,
+			&photoThumbnail.BlurHash,
+			&photoThumbnail.DominantColor,
+			&photoThumbnail.CreatedAt,
+			&lastPosition,
+		)
+		if err != nil {
+			return nil, err
+		}
+		response.Photos = append(response.Photos, photoThumbnail)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	albums := []models.Album{response.Album}
+	if err := attachCoverRenditions(db, ctx, albums); err != nil {
+		return nil, err
+	}
+	response.Album = albums[0]
+	if err := attachRenditions(db, ctx, response.Photos); err != nil {
+		return nil, err
+	}
+
+	if len(response.Photos) > 0 {
+		response.NextCursor = models.AlbumCursor{
+			Position: lastPosition,
+			ID:       response.Photos[len(response.Photos)-1].ID,
+		}
+	}
+	response.HasMore = (len(response.Photos) == LIMIT)
+
+	return response, nil
+}
+
+// UpdateAlbum applies update to the album. It returns sql.ErrNoRows if the album does not exist,
+// ErrSlugTaken for a duplicate slug and ErrPhotoNotInAlbum for a cover photo outside the album.
+func UpdateAlbum(db *sql.DB, ctx context.Context, id string, update AlbumUpdate) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := albumExists(tx, ctx, id); err != nil {
+		return err
+	}
+
+	setClauses := []string{"updated_at = ?"}
+	args := []any{time.Now()}
+
+	if update.Title != nil {
+		setClauses = append(setClauses, "title = ?")
+		args = append(args, *update.Title)
+	}
+	if update.Description != nil {
+		setClauses = append(setClauses, "description = ?")
+		args = append(args, *update.Description)
+	}
+	if update.Slug != nil {
+		taken, err := slugTaken(tx, ctx, *update.Slug, id)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSlugTaken
+		}
+		setClauses = append(setClauses, "slug = ?")
+		args = append(args, *update.Slug)
+	}
+	if update.CoverPhotoID != nil {
+		if *update.CoverPhotoID == "" {
+			setClauses = append(setClauses, "cover_photo_id = NULL")
+		} else {
+			var inAlbum int
+			err := tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM album_photos WHERE album_id = ? AND photo_id = ?`,
+				id, *update.CoverPhotoID,
+			).Scan(&inAlbum)
+			if err != nil {
+				return err
+			}
+			if inAlbum == 0 {
+				return ErrPhotoNotInAlbum
+			}
+			setClauses = append(setClauses, "cover_photo_id = ?")
+			args = append(args, *update.CoverPhotoID)
+		}
+	}
+
+	args = append(args, id)
+	updateAlbumSQL := fmt.Sprintf("UPDATE albums SET %s WHERE id = ?", strings.Join(setClauses, ", "))
+	if _, err := tx.ExecContext(ctx, updateAlbumSQL, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAlbum removes the album and its photo links, the photos themselves are kept.
+// It returns sql.ErrNoRows if the album does not exist.
+func DeleteAlbum(db *sql.DB, ctx context.Context, id string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM albums WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if rowsDeleted, _ := res.RowsAffected(); rowsDeleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddPhotosToAlbum appends the photos to the end of the album in the given order.
+// Photos already in the album keep their position. It returns the number of photos added,
+// sql.ErrNoRows if the album does not exist and ErrPhotoNotFound for unknown photo ids.
+func AddPhotosToAlbum(db *sql.DB, ctx context.Context, albumID string, photoIDs []string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := albumExists(tx, ctx, albumID); err != nil {
+		return 0, err
+	}
+
+	photoIDs = uniqueIDs(photoIDs)
+	if err := photosExist(tx, ctx, photoIDs); err != nil {
+		return 0, err
+	}
+
+	var nextPosition int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(position) + 1, 0) FROM album_photos WHERE album_id = ?`, albumID,
+	).Scan(&nextPosition)
+	if err != nil {
+		return 0, err
+	}
+
+	var added int
+	now := time.Now()
+	for _, photoID := range photoIDs {
+		res, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO album_photos (album_id, photo_id, position, added_at)
+			VALUES (?, ?, ?, ?)`,
+			albumID, photoID, nextPosition, now,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if inserted, _ := res.RowsAffected(); inserted > 0 {
+			added++
+			nextPosition++
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE albums SET updated_at = ? WHERE id = ?`, now, albumID); err != nil {
+		return 0, err
+	}
+
+	return added, tx.Commit()
+}
+
+// RemovePhotosFromAlbum unlinks the photos from the album and clears the cover if it was one of them.
+// It returns the number of photos removed and sql.ErrNoRows if the album does not exist.
+func RemovePhotosFromAlbum(db *sql.DB, ctx context.Context, albumID string, photoIDs []string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := albumExists(tx, ctx, albumID); err != nil {
+		return 0, err
+	}
+
+	photoIDs = uniqueIDs(photoIDs)
+	if len(photoIDs) == 0 {
+		return 0, tx.Commit()
+	}
+
+	placeholders := make([]string, len(photoIDs))
+	args := []any{albumID}
+	for i, photoID := range photoIDs {
+		placeholders[i] = "?"
+		args = append(args, photoID)
+	}
+	inClause := strings.Join(placeholders, ",")
+
+	res, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM album_photos WHERE album_id = ? AND photo_id IN (%s)`, inClause), args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := res.RowsAffected()
+
+	clearCover := fmt.Sprintf(`
+		UPDATE albums SET cover_photo_id = NULL
+		WHERE id = ? AND cover_photo_id IN (%s)`, inClause)
+	if _, err := tx.ExecContext(ctx, clearCover, args...); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE albums SET updated_at = ? WHERE id = ?`, time.Now(), albumID); err != nil {
+		return 0, err
+	}
+
+	return int(removed), tx.Commit()
+}
+
+// ReorderAlbumPhotos puts the listed photos first, in the given order, followed by the
+// remaining album photos in their current order. Positions are rewritten as 0..n-1.
+// It returns sql.ErrNoRows if the album does not exist and ErrPhotoNotInAlbum for ids outside the album.
+func ReorderAlbumPhotos(db *sql.DB, ctx context.Context, albumID string, photoIDs []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := albumExists(tx, ctx, albumID); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT photo_id FROM album_photos WHERE album_id = ? ORDER BY position ASC, photo_id ASC`, albumID,
+	)
+	if err != nil {
+		return err
+	}
+	var currentOrder []string
+	for rows.Next() {
+		var photoID string
+		if err := rows.Scan(&photoID); err != nil {
+			rows.Close()
+			return err
+		}
+		currentOrder = append(currentOrder, photoID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	inAlbum := make(map[string]bool, len(currentOrder))
+	for _, photoID := range currentOrder {
+		inAlbum[photoID] = true
+	}
+
+	photoIDs = uniqueIDs(photoIDs)
+	listed := make(map[string]bool, len(photoIDs))
+	newOrder := make([]string, 0, len(currentOrder))
+	for _, photoID := range photoIDs {
+		if !inAlbum[photoID] {
+			return ErrPhotoNotInAlbum
+		}
+		listed[photoID] = true
+		newOrder = append(newOrder, photoID)
+	}
+	for _, photoID := range currentOrder {
+		if !listed[photoID] {
+			newOrder = append(newOrder, photoID)
+		}
+	}
+
+	for position, photoID := range newOrder {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE album_photos SET position = ? WHERE album_id = ? AND photo_id = ?`,
+			position, albumID, photoID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE albums SET updated_at = ? WHERE id = ?`, time.Now(), albumID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func albumExists(tx *sql.Tx, ctx context.Context, id string) error {
+	var found int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM albums WHERE id = ?`, id).Scan(&found); err != nil {
+		return err
+	}
+	if found == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func slugTaken(tx *sql.Tx, ctx context.Context, slug string, exceptID string) (bool, error) {
+	var found int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM albums WHERE slug = ? AND id != ?`, slug, exceptID).Scan(&found)
+	if err != nil {
+		return false, err
+	}
+	return found > 0, nil
+}
+
+func photosExist(tx *sql.Tx, ctx context.Context, photoIDs []string) error {
+	if len(photoIDs) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(photoIDs))
+	args := make([]any, len(photoIDs))
+	for i, photoID := range photoIDs {
+		placeholders[i] = "?"
+		args[i] = photoID
+	}
+
+	var found int
+	countPhotos := fmt.Sprintf(`SELECT COUNT(*) FROM photos WHERE id IN (%s) AND deleted_at IS NULL`, strings.Join(placeholders, ","))
+	if err := tx.QueryRowContext(ctx, countPhotos, args...).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(photoIDs) {
+		return ErrPhotoNotFound
+	}
+	return nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	return unique
+}
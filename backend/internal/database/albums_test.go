@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"shutterdev/backend/internal/models"
+)
+
+// newAlbumTestDB returns a migrated database holding the photos p1..p5, p6 in the trash,
+// and an empty album.
+func newAlbumTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO photos (id, image_key, thumbnail_key, thumbnail_width, thumbnail_height, deleted_at) VALUES
+			('p1', 'p1.jpg', 'p1_thumb.webp', 400, 300, NULL),
+			('p2', 'p2.jpg', 'p2_thumb.webp', 400, 300, NULL),
+			('p3', 'p3.jpg', 'p3_thumb.webp', 400, 300, NULL),
+			('p4', 'p4.jpg', 'p4_thumb.webp', 400, 300, NULL),
+			('p5', 'p5.jpg', 'p5_thumb.webp', 400, 300, NULL),
+			('p6', 'p6.jpg', 'p6_thumb.webp', 400, 300, CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatalf("insert photos: %v", err)
+	}
+
+	albumID, err := CreateAlbum(db, context.Background(), &models.Album{Title: "Summer"})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	return db, albumID
+}
+
+// albumOrder lists the photo ids of the album by position.
+func albumOrder(t *testing.T, db *sql.DB, albumID string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT photo_id FROM album_photos WHERE album_id = ? ORDER BY position`, albumID)
+	if err != nil {
+		t.Fatalf("query album photos: %v", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan album photo: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func addToAlbum(t *testing.T, db *sql.DB, albumID string, photoIDs ...string) {
+	t.Helper()
+	if _, err := AddPhotosToAlbum(db, context.Background(), albumID, photoIDs); err != nil {
+		t.Fatalf("AddPhotosToAlbum(%v): %v", photoIDs, err)
+	}
+}
+
+func TestAddPhotosToAlbum(t *testing.T) {
+	db, albumID := newAlbumTestDB(t)
+	ctx := context.Background()
+
+	added, err := AddPhotosToAlbum(db, ctx, albumID, []string{"p2", "p1"})
+	if err != nil || added != 2 {
+		t.Fatalf("AddPhotosToAlbum = %d, %v, want 2 added", added, err)
+	}
+
+	// p1 is already in the album and keeps its position, p3 goes to the end
+	added, err = AddPhotosToAlbum(db, ctx, albumID, []string{"p1", "p3", "p3"})
+	if err != nil || added != 1 {
+		t.Fatalf("AddPhotosToAlbum = %d, %v, want 1 added", added, err)
+	}
+	if got, want := albumOrder(t, db, albumID), []string{"p2", "p1", "p3"}; !slices.Equal(got, want) {
+		t.Errorf("album order = %v, want %v", got, want)
+	}
+
+	for _, photoIDs := range [][]string{{"p4", "p6"}, {"missing"}} {
+		if _, err := AddPhotosToAlbum(db, ctx, albumID, photoIDs); !errors.Is(err, ErrPhotoNotFound) {
+			t.Errorf("AddPhotosToAlbum(%v) error = %v, want ErrPhotoNotFound", photoIDs, err)
+		}
+	}
+	if got := albumOrder(t, db, albumID); len(got) != 3 {
+		t.Errorf("a rejected add changed the album: %v", got)
+	}
+
+	if _, err := AddPhotosToAlbum(db, ctx, "no-such-album", []string{"p1"}); err != sql.ErrNoRows {
+		t.Errorf("unknown album error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestReorderAlbumPhotos(t *testing.T) {
+	db, albumID := newAlbumTestDB(t)
+	ctx := context.Background()
+	addToAlbum(t, db, albumID, "p1", "p2", "p3", "p4", "p5")
+
+	// the listed photos move to the front, the others keep their relative order
+	if err := ReorderAlbumPhotos(db, ctx, albumID, []string{"p4", "p2"}); err != nil {
+		t.Fatalf("ReorderAlbumPhotos: %v", err)
+	}
+	if got, want := albumOrder(t, db, albumID), []string{"p4", "p2", "p1", "p3", "p5"}; !slices.Equal(got, want) {
+		t.Errorf("album order = %v, want %v", got, want)
+	}
+
+	var positions []int
+	rows, err := db.Query(`SELECT position FROM album_photos WHERE album_id = ? ORDER BY position`, albumID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var position int
+		rows.Scan(&position)
+		positions = append(positions, position)
+	}
+	rows.Close()
+	if want := []int{0, 1, 2, 3, 4}; !slices.Equal(positions, want) {
+		t.Errorf("positions = %v, want %v", positions, want)
+	}
+
+	if err := ReorderAlbumPhotos(db, ctx, albumID, []string{"p1", "p6"}); !errors.Is(err, ErrPhotoNotInAlbum) {
+		t.Errorf("reorder with a photo outside the album error = %v, want ErrPhotoNotInAlbum", err)
+	}
+	if got, want := albumOrder(t, db, albumID), []string{"p4", "p2", "p1", "p3", "p5"}; !slices.Equal(got, want) {
+		t.Errorf("a rejected reorder changed the album to %v", got)
+	}
+}
+
+func TestRemovingTheCoverPhotoClearsIt(t *testing.T) {
+	db, albumID := newAlbumTestDB(t)
+	ctx := context.Background()
+	addToAlbum(t, db, albumID, "p1", "p2")
+
+	cover := "p1"
+	if err := UpdateAlbum(db, ctx, albumID, AlbumUpdate{CoverPhotoID: &cover}); err != nil {
+		t.Fatalf("set cover: %v", err)
+	}
+
+	removed, err := RemovePhotosFromAlbum(db, ctx, albumID, []string{"p2"})
+	if err != nil || removed != 1 {
+		t.Fatalf("RemovePhotosFromAlbum = %d, %v, want 1 removed", removed, err)
+	}
+	album, err := GetAlbumByID(db, ctx, albumID)
+	if err != nil || album == nil {
+		t.Fatalf("GetAlbumByID: %v", err)
+	}
+	if album.CoverPhotoID == nil || *album.CoverPhotoID != "p1" {
+		t.Errorf("cover = %v, removing another photo must keep it", album.CoverPhotoID)
+	}
+
+	if _, err := RemovePhotosFromAlbum(db, ctx, albumID, []string{"p1"}); err != nil {
+		t.Fatalf("RemovePhotosFromAlbum: %v", err)
+	}
+	album, err = GetAlbumByID(db, ctx, albumID)
+	if err != nil || album == nil {
+		t.Fatalf("GetAlbumByID: %v", err)
+	}
+	if album.CoverPhotoID != nil || album.CoverPhoto != nil {
+		t.Errorf("cover = %v (%v), want it cleared with the photo", album.CoverPhotoID, album.CoverPhoto)
+	}
+}
+
+func TestGetAlbumPhotosBySlugPages(t *testing.T) {
+	db, albumID := newAlbumTestDB(t)
+	ctx := context.Background()
+	addToAlbum(t, db, albumID, "p3", "p1", "p5", "p2", "p4")
+	// a photo moved to the trash drops out of the album without leaving a gap in the pages
+	if _, err := db.Exec(`UPDATE photos SET deleted_at = CURRENT_TIMESTAMP WHERE id = 'p5'`); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var cursor *models.AlbumCursor
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatal("paging did not stop")
+		}
+		response, err := GetAlbumPhotosBySlug(db, ctx, "summer", cursor, 2)
+		if err != nil || response == nil {
+			t.Fatalf("GetAlbumPhotosBySlug page %d: %v", page, err)
+		}
+		if response.Album.ID != albumID {
+			t.Fatalf("album = %s, want %s", response.Album.ID, albumID)
+		}
+		for _, photo := range response.Photos {
+			got = append(got, photo.ID)
+		}
+		if !response.HasMore {
+			break
+		}
+		next := response.NextCursor
+		cursor = &next
+	}
+	if want := []string{"p3", "p1", "p2", "p4"}; !slices.Equal(got, want) {
+		t.Errorf("paged photos = %v, want %v", got, want)
+	}
+
+	response, err := GetAlbumPhotosBySlug(db, ctx, "no-such-album", nil, 2)
+	if err != nil || response != nil {
+		t.Errorf("unknown slug = %v, %v, want nil", response, err)
+	}
+}
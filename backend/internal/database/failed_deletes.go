@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+	"time"
+)
+
+const selectFailedDeletesSQL = `
+	SELECT id, COALESCE(web_key, ''), COALESCE(thumbnail_key, ''), extra_keys, attempts, last_error,
+		created_at, last_attempt_at, next_attempt_at, gave_up_at
+	FROM failed_storage_deletes`
+
+// GetFailedDeletes returns the whole retry queue, rows that are still being retried first.
+func GetFailedDeletes(db *sql.DB, ctx context.Context) ([]models.FailedDelete, error) {
+	return queryFailedDeletes(db, ctx, selectFailedDeletesSQL+`
+	ORDER BY gave_up_at IS NOT NULL, next_attempt_at IS NOT NULL, next_attempt_at, id`)
+}
+
+// GetDueFailedDeletes returns at most limit rows that have not been given up on and whose
+// next attempt is due at now. Rows that were never attempted are always due.
+func GetDueFailedDeletes(db *sql.DB, ctx context.Context, now time.Time, limit int) ([]models.FailedDelete, error) {
+	return queryFailedDeletes(db, ctx, selectFailedDeletesSQL+`
+	WHERE gave_up_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+	ORDER BY next_attempt_at IS NOT NULL, next_attempt_at, id
+	LIMIT ?`, now.UTC(), limit)
+}
+
+func queryFailedDeletes(db *sql.DB, ctx context.Context, query string, args ...any) ([]models.FailedDelete, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failedDeletes := []models.FailedDelete{}
+	for rows.Next() {
+		var fd models.FailedDelete
+		var extraKeys string
+		var createdAt, lastAttemptAt, nextAttemptAt, gaveUpAt sql.NullTime
+		if err := rows.Scan(
+			&fd.ID,
+			&fd.ImageKey,
+			&fd.ThumbnailKey,
+			&extraKeys,
+			&fd.Attempts,
+			&fd.LastError,
+			&createdAt,
+			&lastAttemptAt,
+			&nextAttemptAt,
+			&gaveUpAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if extraKeys != "" {
+			if err := json.Unmarshal([]byte(extraKeys), &fd.ExtraKeys); err != nil {
+				return nil, fmt.Errorf("invalid extra_keys for failed_storage_deletes row (%s): %v", fd.ID, err)
+			}
+		}
+		fd.CreatedAt = nullTimePtr(createdAt)
+		fd.LastAttemptAt = nullTimePtr(lastAttemptAt)
+		fd.NextAttemptAt = nullTimePtr(nextAttemptAt)
+		fd.GaveUpAt = nullTimePtr(gaveUpAt)
+		failedDeletes = append(failedDeletes, fd)
+	}
+
+	return failedDeletes, rows.Err()
+}
+
+// RecordFailedDeleteAttempt stores the outcome of a failed retry. A nil nextAttemptAt means
+// the worker gives up on the row, it then stays in the queue until it is retried by hand.
+func RecordFailedDeleteAttempt(db *sql.DB, ctx context.Context, id string, attemptErr error, nextAttemptAt *time.Time) error {
+	now := time.Now().UTC()
+
+	var next, gaveUp sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: nextAttemptAt.UTC(), Valid: true}
+	} else {
+		gaveUp = sql.NullTime{Time: now, Valid: true}
+	}
+
+	_, err := db.ExecContext(ctx, `
+	UPDATE failed_storage_deletes
+	SET attempts = attempts + 1, last_error = ?, last_attempt_at = ?, next_attempt_at = ?, gave_up_at = ?
+	WHERE id = ?`, attemptErr.Error(), now, next, gaveUp, id)
+	if err != nil {
+		return fmt.Errorf("Failed to record attempt for failed_storage_deletes row (%s): %v", id, err)
+	}
+	return nil
+}
+
+// RemoveFailedDeletes drops rows whose files have been deleted from storage.
+func RemoveFailedDeletes(db *sql.DB, ctx context.Context, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	deleteFromFailedStore := fmt.Sprintf(`DELETE FROM failed_storage_deletes WHERE id IN (%s)`, strings.Join(placeholders, ","))
+	res, err := db.ExecContext(ctx, deleteFromFailedStore, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// encodeExtraKeys stores the rendition keys of a photo, which do not fit the web_key and
+// thumbnail_key columns, as a JSON list.
+func encodeExtraKeys(photo models.Photo) (string, error) {
+	var extraKeys []string
+	for _, rendition := range photo.Renditions {
+		if rendition.Key != photo.ImageKey && rendition.Key != photo.ThumbnailKey {
+			extraKeys = append(extraKeys, rendition.Key)
+		}
+	}
+	if len(extraKeys) == 0 {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(extraKeys)
+	return string(encoded), err
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
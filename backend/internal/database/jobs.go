@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"time"
+)
+
+const selectUploadJobsSQL = `
+	SELECT id, file_name, spool_path, fields, stage, COALESCE(photo_id, ''), error, code, duplicates,
+		created_at, updated_at
+	FROM upload_jobs`
+
+// CreateUploadJob stores a job that was just received.
+func CreateUploadJob(db *sql.DB, ctx context.Context, job *models.UploadJob) error {
+	return createUploadJob(db, ctx, job)
+}
+
+func createUploadJob(db execer, ctx context.Context, job *models.UploadJob) error {
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO upload_jobs (id, file_name, spool_path, fields, stage, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.FileName, job.SpoolPath, job.Fields, job.Stage, job.CreatedAt.UTC(), job.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("Failed to create upload job (%s): %v", job.ID, err)
+	}
+	return nil
+}
+
+// GetUploadJob returns a job, or sql.ErrNoRows when there is none with that ID.
+func GetUploadJob(db *sql.DB, ctx context.Context, id string) (*models.UploadJob, error) {
+	jobs, err := queryUploadJobs(db, ctx, selectUploadJobsSQL+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &jobs[0], nil
+}
+
+// GetUnfinishedUploadJobs returns the jobs that were neither indexed nor failed, oldest first.
+// They are left over from a restart and start over from the spooled original.
+func GetUnfinishedUploadJobs(db *sql.DB, ctx context.Context) ([]models.UploadJob, error) {
+	return queryUploadJobs(db, ctx, selectUploadJobsSQL+`
+	WHERE stage NOT IN (?, ?)
+	ORDER BY created_at, id`, models.JobIndexed, models.JobFailed)
+}
+
+func queryUploadJobs(db *sql.DB, ctx context.Context, query string, args ...any) ([]models.UploadJob, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.UploadJob{}
+	for rows.Next() {
+		var job models.UploadJob
+		var duplicates string
+		if err := rows.Scan(
+			&job.ID,
+			&job.FileName,
+			&job.SpoolPath,
+			&job.Fields,
+			&job.Stage,
+			&job.PhotoID,
+			&job.Error,
+			&job.Code,
+			&duplicates,
+			&job.CreatedAt,
+			&job.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if duplicates != "" {
+			if err := json.Unmarshal([]byte(duplicates), &job.Duplicates); err != nil {
+				return nil, fmt.Errorf("invalid duplicates for upload job (%s): %v", job.ID, err)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// UpdateUploadJob stores the stage and outcome of a job.
+func UpdateUploadJob(db *sql.DB, ctx context.Context, job *models.UploadJob) error {
+	return updateUploadJob(db, ctx, job)
+}
+
+func updateUploadJob(db execer, ctx context.Context, job *models.UploadJob) error {
+	var duplicates []byte
+	if len(job.Duplicates) > 0 {
+		var err error
+		if duplicates, err = json.Marshal(job.Duplicates); err != nil {
+			return err
+		}
+	}
+
+	var photoID sql.NullString
+	if job.PhotoID != "" {
+		photoID = sql.NullString{String: job.PhotoID, Valid: true}
+	}
+
+	_, err := db.ExecContext(ctx, `
+	UPDATE upload_jobs
+	SET stage = ?, photo_id = ?, error = ?, code = ?, duplicates = ?, updated_at = ?
+	WHERE id = ?`, job.Stage, photoID, job.Error, job.Code, string(duplicates), job.UpdatedAt.UTC(), job.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update upload job (%s): %v", job.ID, err)
+	}
+	return nil
+}
+
+// DeleteFinishedUploadJobs removes indexed and failed jobs last updated before the cutoff.
+func DeleteFinishedUploadJobs(db *sql.DB, ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+	DELETE FROM upload_jobs WHERE stage IN (?, ?) AND updated_at < ?`,
+		models.JobIndexed, models.JobFailed, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
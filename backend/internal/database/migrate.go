@@ -0,0 +1,312 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migration is one versioned step of the schema. Up runs inside its own transaction
+// together with the schema_migrations bookkeeping row, so a step is either fully applied or not at all.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// MigrationStatus describes a known migration and whether it has been applied to the database.
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	AppliedAt   *time.Time `json:"appliedAt"`
+}
+
+type columnDefinition struct {
+	name       string
+	definition string
+}
+
+const createSchemaMigrationsSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	"version" INTEGER NOT NULL PRIMARY KEY,
+	"description" TEXT NOT NULL,
+	"applied_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`
+
+// Migrate applies every pending migration in version order and returns the ones it applied.
+// It stops at the first failure, leaving the database at the last successful version.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	return runMigrations(db, false)
+}
+
+// MigrateDryRun applies every pending migration inside a transaction and rolls it back,
+// returning the migrations that would have been applied. Errors are reported exactly like Migrate.
+func MigrateDryRun(db *sql.DB) ([]Migration, error) {
+	return runMigrations(db, true)
+}
+
+// GetMigrationStatus lists every known migration with its applied state.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	if err := validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statusList := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+		}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statusList = append(statusList, status)
+	}
+
+	return statusList, nil
+}
+
+func runMigrations(db *sql.DB, dryRun bool) ([]Migration, error) {
+	if err := validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	if dryRun {
+		// later migrations depend on earlier ones, so the whole run shares one transaction that is never committed
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		for i, m := range pending {
+			if err := applyMigration(tx, m); err != nil {
+				return pending[:i], fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+			log.Printf("[MIGRATE:DRY-RUN] Migration %d (%s) would be applied", m.Version, m.Description)
+		}
+		return pending, nil
+	}
+
+	var ran []Migration
+	for _, m := range pending {
+		tx, err := db.Begin()
+		if err != nil {
+			return ran, err
+		}
+
+		if err := applyMigration(tx, m); err != nil {
+			tx.Rollback()
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) could not be committed: %w", m.Version, m.Description, err)
+		}
+
+		log.Printf("[MIGRATE] Applied migration %d (%s)", m.Version, m.Description)
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+func applyMigration(tx *sql.Tx, m Migration) error {
+	if _, err := tx.Exec(createSchemaMigrationsSQL); err != nil {
+		return fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Description, time.Now().UTC(),
+	)
+	return err
+}
+
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	// a missing table just means nothing has been applied yet, it is created by the first migration run
+	var tableCount int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&tableCount)
+	if err != nil {
+		return nil, err
+	}
+	if tableCount == 0 {
+		return applied, nil
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// validateMigrations guards against the list being edited out of order by mistake.
+func validateMigrations() error {
+	if !sort.SliceIsSorted(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	}) {
+		return fmt.Errorf("migrations are not sorted by version")
+	}
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return nil
+}
+
+// execStatements builds a migration step that runs each statement in order.
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumnsIfMissing builds a migration step that only adds the columns table does not have yet.
+func addColumnsIfMissing(table string, columns []columnDefinition) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		existing, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+
+		for _, column := range columns {
+			if existing[column.name] {
+				continue
+			}
+			_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "%s" %s`, table, column.name, column.definition))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// tableColumns returns the set of column names currently present on table.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// renameURLColumnsToKeys renames each URL column of table to its key column and rewrites every
+// value from a public URL into the storage key it points at.
+func renameURLColumnsToKeys(tx *sql.Tx, table string, renames map[string]string) error {
+	for urlColumn, keyColumn := range renames {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN "%s" TO "%s"`, table, urlColumn, keyColumn)); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(fmt.Sprintf(`SELECT rowid, "%s" FROM %s WHERE "%s" IS NOT NULL AND "%s" != ''`, keyColumn, table, keyColumn, keyColumn))
+		if err != nil {
+			return err
+		}
+
+		converted := make(map[int64]string)
+		for rows.Next() {
+			var rowID int64
+			var fileURL string
+			if err := rows.Scan(&rowID, &fileURL); err != nil {
+				rows.Close()
+				return err
+			}
+			converted[rowID] = storageKeyFromURL(fileURL)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for rowID, key := range converted {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET "%s" = ? WHERE rowid = ?`, table, keyColumn), key, rowID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storageKeyFromURL recovers the key of an uploaded file from its public URL. Keys always look like
+// <web|thumbnails>/YYYY/MM/DD/<uuid>.<ext>, so whatever path the public base URL added in front
+// (a bucket name for path-style URLs, the /media mount of local storage) is dropped.
+func storageKeyFromURL(fileURL string) string {
+	filePath := fileURL
+	if parsedURL, err := url.Parse(fileURL); err == nil {
+		filePath = parsedURL.Path
+	}
+
+	segments := strings.Split(strings.Trim(path.Clean("/"+filePath), "/"), "/")
+	if n := len(segments); n >= 5 && (segments[n-5] == "web" || segments[n-5] == "thumbnails") {
+		return strings.Join(segments[n-5:], "/")
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// withMigrations swaps the migration history for the duration of a test.
+func withMigrations(t *testing.T, list []Migration) {
+	t.Helper()
+	saved := migrations
+	migrations = list
+	t.Cleanup(func() { migrations = saved })
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count); err != nil {
+		t.Fatalf("look up table %s: %v", name, err)
+	}
+	return count > 0
+}
+
+func TestMigrateAppliesEverythingOnce(t *testing.T) {
+	db := openTestDB(t)
+
+	ran, err := Migrate(db)
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if len(ran) != len(migrations) {
+		t.Fatalf("first run applied %d migrations, want %d", len(ran), len(migrations))
+	}
+
+	ran, err = Migrate(db)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if len(ran) != 0 {
+		t.Fatalf("second run applied %d migrations, want 0", len(ran))
+	}
+
+	statusList, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, status := range statusList {
+		if !status.Applied || status.AppliedAt == nil {
+			t.Errorf("migration %d (%s) not reported as applied", status.Version, status.Description)
+		}
+	}
+}
+
+func TestMigrateDryRunLeavesDatabaseUntouched(t *testing.T) {
+	db := openTestDB(t)
+
+	ran, err := MigrateDryRun(db)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(ran) != len(migrations) {
+		t.Fatalf("dry run reported %d migrations, want %d", len(ran), len(migrations))
+	}
+	if tableExists(t, db, "photos") || tableExists(t, db, "schema_migrations") {
+		t.Fatal("dry run left tables behind")
+	}
+
+	statusList, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, status := range statusList {
+		if status.Applied {
+			t.Errorf("migration %d reported as applied after a dry run", status.Version)
+		}
+	}
+}
+
+func TestMigrateStopsAtFailure(t *testing.T) {
+	failure := errors.New("boom")
+	withMigrations(t, []Migration{
+		{Version: 1, Description: "first", Up: execStatements(`CREATE TABLE first (id INTEGER);`)},
+		{Version: 2, Description: "broken", Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE half_done (id INTEGER);`); err != nil {
+				return err
+			}
+			return failure
+		}},
+		{Version: 3, Description: "third", Up: execStatements(`CREATE TABLE third (id INTEGER);`)},
+	})
+	db := openTestDB(t)
+
+	ran, err := Migrate(db)
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	if len(ran) != 1 || ran[0].Version != 1 {
+		t.Fatalf("applied %v, want only version 1", ran)
+	}
+	if !tableExists(t, db, "first") {
+		t.Error("migration 1 was not kept")
+	}
+	if tableExists(t, db, "half_done") {
+		t.Error("failed migration 2 was not rolled back")
+	}
+	if tableExists(t, db, "third") {
+		t.Error("migration 3 ran after migration 2 failed")
+	}
+
+	statusList, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, status := range statusList {
+		if want := status.Version == 1; status.Applied != want {
+			t.Errorf("migration %d applied = %v, want %v", status.Version, status.Applied, want)
+		}
+	}
+}
+
+func TestValidateMigrations(t *testing.T) {
+	noop := func(*sql.Tx) error { return nil }
+	tests := []struct {
+		name    string
+		list    []Migration
+		wantErr bool
+	}{
+		{"sorted", []Migration{{Version: 1, Up: noop}, {Version: 2, Up: noop}, {Version: 5, Up: noop}}, false},
+		{"unsorted", []Migration{{Version: 2, Up: noop}, {Version: 1, Up: noop}}, true},
+		{"duplicate", []Migration{{Version: 1, Up: noop}, {Version: 1, Up: noop}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withMigrations(t, tt.list)
+			if err := validateMigrations(); (err != nil) != tt.wantErr {
+				t.Errorf("validateMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := validateMigrations(); err != nil {
+		t.Errorf("the shipped migrations are invalid: %v", err)
+	}
+}
+
+func TestStorageKeyFromURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"r2 public domain", "https://pub-123.r2.dev/web/2024/05/01/abc.webp", "web/2024/05/01/abc.webp"},
+		{"path-style bucket", "https://s3.example.com/photos/thumbnails/2024/05/01/abc.webp", "thumbnails/2024/05/01/abc.webp"},
+		{"local media mount", "http://localhost:8080/media/web/2024/05/01/abc.webp", "web/2024/05/01/abc.webp"},
+		{"relative local url", "/media/thumbnails/2024/05/01/abc.webp", "thumbnails/2024/05/01/abc.webp"},
+		{"query string", "https://cdn.example.com/web/2024/05/01/abc.webp?v=2", "web/2024/05/01/abc.webp"},
+		{"bare key", "web/2024/05/01/abc.webp", "web/2024/05/01/abc.webp"},
+		{"unknown layout keeps the path", "https://cdn.example.com/legacy/abc.jpg", "legacy/abc.jpg"},
+		{"dot segments", "https://cdn.example.com/media/../web/2024/05/01/abc.webp", "web/2024/05/01/abc.webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storageKeyFromURL(tt.url); got != tt.want {
+				t.Errorf("storageKeyFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	// the schema databases had before migrations existed, with URLs instead of keys
+	_, err := db.Exec(`
+		CREATE TABLE photos (
+			"id" TEXT NOT NULL PRIMARY KEY,
+			"image_url" TEXT,
+			"thumbnail_url" TEXT,
+			"thumbnail_width" INT,
+			"thumbnail_height" INT,
+			"aperture" TEXT,
+			"shutter_speed" TEXT,
+			"iso" TEXT,
+			"created_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE failed_storage_deletes (
+			"id" TEXT NOT NULL PRIMARY KEY,
+			"web_url" TEXT,
+			"thumbnail_url" TEXT
+		);
+		INSERT INTO photos (id, image_url, thumbnail_url, thumbnail_width, thumbnail_height) VALUES
+			('complete', 'https://pub-1.r2.dev/web/2023/04/01/a.webp', 'https://pub-1.r2.dev/thumbnails/2023/04/01/a.webp', 400, 300),
+			('no-thumbnail', 'https://pub-1.r2.dev/web/2023/04/02/b.webp', NULL, NULL, NULL),
+			('empty-thumbnail', 'https://pub-1.r2.dev/web/2023/04/03/c.webp', '', 400, 300),
+			('no-size', 'https://pub-1.r2.dev/web/2023/04/04/d.webp', 'https://pub-1.r2.dev/thumbnails/2023/04/04/d.webp', NULL, 300);
+		INSERT INTO failed_storage_deletes (id, web_url, thumbnail_url) VALUES
+			('queued', 'https://pub-1.r2.dev/web/2023/03/01/e.webp', NULL);`)
+	if err != nil {
+		t.Fatalf("create legacy database: %v", err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate legacy database: %v", err)
+	}
+
+	var imageKey, thumbnailKey string
+	if err := db.QueryRow(`SELECT image_key, thumbnail_key FROM photos WHERE id = 'complete'`).Scan(&imageKey, &thumbnailKey); err != nil {
+		t.Fatal(err)
+	}
+	if imageKey != "web/2023/04/01/a.webp" || thumbnailKey != "thumbnails/2023/04/01/a.webp" {
+		t.Errorf("keys = %q, %q, want the URLs converted to keys", imageKey, thumbnailKey)
+	}
+
+	rows, err := db.Query(`SELECT photo_id, storage_key, width, height FROM photo_renditions`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var renditions []string
+	for rows.Next() {
+		var photoID, key string
+		var width, height int
+		if err := rows.Scan(&photoID, &key, &width, &height); err != nil {
+			t.Fatal(err)
+		}
+		renditions = append(renditions, fmt.Sprintf("%s %s %dx%d", photoID, key, width, height))
+	}
+	if want := []string{"complete thumbnails/2023/04/01/a.webp 400x300"}; !slices.Equal(renditions, want) {
+		t.Errorf("renditions = %v, want %v", renditions, want)
+	}
+
+	var photos int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM photos`).Scan(&photos); err != nil {
+		t.Fatal(err)
+	}
+	if photos != 4 {
+		t.Errorf("%d photos after migrating, want all 4 kept", photos)
+	}
+
+	queued, err := GetFailedDeletes(db, context.Background())
+	if err != nil {
+		t.Fatalf("GetFailedDeletes: %v", err)
+	}
+	if len(queued) != 1 || queued[0].ImageKey != "web/2023/03/01/e.webp" || queued[0].ThumbnailKey != "" {
+		t.Errorf("failed deletes = %+v, want the queued web URL converted to a key", queued)
+	}
+}
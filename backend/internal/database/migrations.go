@@ -0,0 +1,230 @@
+package database
+
+import "database/sql"
+
+// migrations is the ordered history of the schema. Never edit or reorder an entry that
+// has been released, append a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		// IF NOT EXISTS so databases created before migrations existed are adopted as-is
+		Up: execStatements(
+			`CREATE TABLE IF NOT EXISTS photos (
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"image_url" TEXT,
+				"thumbnail_url" TEXT,
+				"thumbnail_width" INT,
+				"thumbnail_height" INT,
+				"aperture" TEXT,
+				"shutter_speed" TEXT,
+				"iso" TEXT,
+				"created_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);`,
+			`CREATE TABLE IF NOT EXISTS tags (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"name" TEXT UNIQUE
+			);`,
+			`CREATE TABLE IF NOT EXISTS photo_tags (
+				"photo_id" TEXT NOT NULL,
+				"tag_id" INTEGER NOT NULL,
+				FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+				FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE RESTRICT,
+				PRIMARY KEY(photo_id, tag_id)
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_photo_tags_tag_id
+			ON photo_tags(tag_id);`,
+			`CREATE TABLE IF NOT EXISTS failed_storage_deletes (
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"web_url" TEXT,
+				"thumbnail_url" TEXT
+			);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "extended photo metadata (camera, lens, capture time, GPS)",
+		// some databases already received these columns from the pre-migration startup code
+		Up: addColumnsIfMissing("photos", []columnDefinition{
+			{"camera_make", "TEXT NOT NULL DEFAULT ''"},
+			{"camera_model", "TEXT NOT NULL DEFAULT ''"},
+			{"lens_model", "TEXT NOT NULL DEFAULT ''"},
+			{"focal_length", "REAL NOT NULL DEFAULT 0"},
+			{"focal_length_35mm", "INTEGER NOT NULL DEFAULT 0"},
+			{"exposure_compensation", "REAL NOT NULL DEFAULT 0"},
+			{"flash", "TEXT NOT NULL DEFAULT ''"},
+			{"date_taken", "DATETIME"},
+			{"date_taken_offset", "TEXT NOT NULL DEFAULT ''"},
+			{"gps_latitude", "REAL"},
+			{"gps_longitude", "REAL"},
+			{"gps_altitude", "REAL"},
+		}),
+	},
+	{
+		Version:     3,
+		Description: "editable photo title, caption and alt text",
+		Up: execStatements(
+			`ALTER TABLE photos ADD COLUMN "title" TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE photos ADD COLUMN "caption" TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE photos ADD COLUMN "alt_text" TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE photos ADD COLUMN "updated_at" DATETIME`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "albums with ordered photos and cover photo",
+		Up: execStatements(
+			`CREATE TABLE albums (
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"slug" TEXT NOT NULL UNIQUE,
+				"title" TEXT NOT NULL,
+				"description" TEXT NOT NULL DEFAULT '',
+				"cover_photo_id" TEXT,
+				"created_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				"updated_at" DATETIME,
+				FOREIGN KEY(cover_photo_id) REFERENCES photos(id) ON DELETE SET NULL
+			);`,
+			`CREATE TABLE album_photos (
+				"album_id" TEXT NOT NULL,
+				"photo_id" TEXT NOT NULL,
+				"position" INTEGER NOT NULL,
+				"added_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(album_id) REFERENCES albums(id) ON DELETE CASCADE,
+				FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+				PRIMARY KEY(album_id, photo_id)
+			);`,
+			`CREATE INDEX idx_album_photos_position ON album_photos(album_id, position);`,
+			`CREATE INDEX idx_album_photos_photo_id ON album_photos(photo_id);`,
+		),
+	},
+	{
+		Version:     5,
+		Description: "soft delete for photos",
+		Up: execStatements(
+			`ALTER TABLE photos ADD COLUMN "deleted_at" DATETIME`,
+			`CREATE INDEX idx_photos_deleted_at ON photos(deleted_at);`,
+		),
+	},
+	{
+		Version:     6,
+		Description: "retry bookkeeping for failed storage deletes",
+		Up: execStatements(
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "attempts" INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "last_error" TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "created_at" DATETIME`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "last_attempt_at" DATETIME`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "next_attempt_at" DATETIME`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "gave_up_at" DATETIME`,
+			`CREATE INDEX idx_failed_storage_deletes_next_attempt ON failed_storage_deletes(gave_up_at, next_attempt_at);`,
+		),
+	},
+	{
+		Version:     7,
+		Description: "store storage keys instead of public URLs",
+		Up: func(tx *sql.Tx) error {
+			if err := renameURLColumnsToKeys(tx, "photos", map[string]string{
+				"image_url":     "image_key",
+				"thumbnail_url": "thumbnail_key",
+			}); err != nil {
+				return err
+			}
+			return renameURLColumnsToKeys(tx, "failed_storage_deletes", map[string]string{
+				"web_url":       "web_key",
+				"thumbnail_url": "thumbnail_key",
+			})
+		},
+	},
+	{
+		Version:     8,
+		Description: "photo renditions",
+		Up: execStatements(
+			`CREATE TABLE photo_renditions (
+				"photo_id" TEXT NOT NULL,
+				"storage_key" TEXT NOT NULL,
+				"width" INTEGER NOT NULL,
+				"height" INTEGER NOT NULL,
+				"format" TEXT NOT NULL,
+				FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+				PRIMARY KEY(photo_id, storage_key)
+			);`,
+			`CREATE INDEX idx_photo_renditions_photo_id ON photo_renditions(photo_id, width);`,
+			// existing photos keep their single thumbnail as their only rendition, legacy rows
+			// without a complete thumbnail get none instead of failing the NOT NULL columns
+			`INSERT INTO photo_renditions (photo_id, storage_key, width, height, format)
+			SELECT id, thumbnail_key, thumbnail_width, thumbnail_height, 'webp' FROM photos
+			WHERE thumbnail_key IS NOT NULL AND thumbnail_key != ''
+				AND thumbnail_width IS NOT NULL AND thumbnail_height IS NOT NULL;`,
+			`ALTER TABLE failed_storage_deletes ADD COLUMN "extra_keys" TEXT NOT NULL DEFAULT ''`,
+		),
+	},
+	{
+		Version:     9,
+		Description: "blurhash and dominant colour placeholders",
+		Up: execStatements(
+			`ALTER TABLE photos ADD COLUMN "blur_hash" TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE photos ADD COLUMN "dominant_color" TEXT NOT NULL DEFAULT ''`,
+		),
+	},
+	{
+		Version:     10,
+		Description: "photo colour palettes",
+		Up: execStatements(
+			`CREATE TABLE photo_palette (
+				"photo_id" TEXT NOT NULL,
+				"position" INTEGER NOT NULL,
+				"color" TEXT NOT NULL,
+				"weight" REAL NOT NULL,
+				"lab_l" REAL NOT NULL,
+				"lab_a" REAL NOT NULL,
+				"lab_b" REAL NOT NULL,
+				FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+				PRIMARY KEY(photo_id, position)
+			);`,
+		),
+	},
+	{
+		Version:     11,
+		Description: "perceptual hashes for duplicate detection",
+		Up: execStatements(
+			// the 64 bit hash is stored as its signed bit pattern, NULL for photos uploaded before
+			`ALTER TABLE photos ADD COLUMN "phash" INTEGER`,
+		),
+	},
+	{
+		Version:     12,
+		Description: "asynchronous upload jobs",
+		Up: execStatements(
+			`CREATE TABLE upload_jobs (
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"file_name" TEXT NOT NULL,
+				"spool_path" TEXT NOT NULL,
+				"fields" TEXT NOT NULL DEFAULT '',
+				"stage" TEXT NOT NULL,
+				"photo_id" TEXT,
+				"error" TEXT NOT NULL DEFAULT '',
+				"code" TEXT NOT NULL DEFAULT '',
+				"duplicates" TEXT NOT NULL DEFAULT '',
+				"created_at" DATETIME NOT NULL,
+				"updated_at" DATETIME NOT NULL,
+				FOREIGN KEY(photo_id) REFERENCES photos(id) ON DELETE SET NULL
+			);`,
+			`CREATE INDEX idx_upload_jobs_stage ON upload_jobs(stage, created_at);`,
+		),
+	},
+	{
+		Version:     13,
+		Description: "resumable tus uploads",
+		Up: execStatements(
+			`CREATE TABLE tus_uploads (
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"upload_length" INTEGER NOT NULL,
+				"metadata" TEXT NOT NULL DEFAULT '',
+				"spool_path" TEXT NOT NULL,
+				"created_at" DATETIME NOT NULL,
+				"expires_at" DATETIME NOT NULL,
+				"completed_at" DATETIME
+			);`,
+			`CREATE INDEX idx_tus_uploads_expires_at ON tus_uploads(expires_at);`,
+		),
+	},
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+)
+
+func insertPalette(tx *sql.Tx, photoID string, palette []models.PaletteColor) error {
+	if len(palette) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(palette))
+	args := make([]any, 0, len(palette)*7)
+	for i, color := range palette {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, photoID, i, color.Color, color.Weight, color.L, color.A, color.B)
+	}
+
+	insertPaletteSQL := fmt.Sprintf(`
+	INSERT INTO photo_palette (photo_id, position, color, weight, lab_l, lab_a, lab_b)
+	VALUES %s`, strings.Join(placeholders, ","))
+
+	if _, err := tx.Exec(insertPaletteSQL, args...); err != nil {
+		return fmt.Errorf("could not insert palette: %w", err)
+	}
+	return nil
+}
+
+// getPalette returns the palette of a photo, the colour covering most of it first.
+func getPalette(db queryer, ctx context.Context, photoID string) ([]models.PaletteColor, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT color, weight, lab_l, lab_a, lab_b
+	FROM photo_palette
+	WHERE photo_id = ?
+	ORDER BY position`, photoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	palette := []models.PaletteColor{}
+	for rows.Next() {
+		var color models.PaletteColor
+		if err := rows.Scan(&color.Color, &color.Weight, &color.L, &color.A, &color.B); err != nil {
+			return nil, err
+		}
+		palette = append(palette, color)
+	}
+	return palette, rows.Err()
+}
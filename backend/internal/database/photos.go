@@ -18,6 +18,17 @@ type PhotosResponse struct {
 }
 
 func CreatePhoto(db *sql.DB, photo *models.Photo) (string, error) {
+	return createPhoto(db, photo, nil)
+}
+
+// CreatePhotoForJob stores the photo of an upload job and stores job in the same transaction, with
+// PhotoID set to the new photo. Once the photo exists the job can never be left unfinished, and
+// processed a second time, after a crash.
+func CreatePhotoForJob(db *sql.DB, photo *models.Photo, job *models.UploadJob) (string, error) {
+	return createPhoto(db, photo, job)
+}
+
+func createPhoto(db *sql.DB, photo *models.Photo, job *models.UploadJob) (string, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return "", err
@@ -25,25 +36,50 @@ func CreatePhoto(db *sql.DB, photo *models.Photo) (string, error) {
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO photos (id, image_url, thumbnail_url, thumbnail_width, thumbnail_height, aperture, shutter_speed, iso, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO photos (
+			id, image_key, thumbnail_key, thumbnail_width, thumbnail_height, aperture, shutter_speed, iso, created_at,
+			camera_make, camera_model, lens_model, focal_length, focal_length_35mm, exposure_compensation, flash,
+			date_taken, date_taken_offset, gps_latitude, gps_longitude, gps_altitude, title, caption, alt_text,
+			blur_hash, dominant_color, phash
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return "", err
 	}
 	defer stmt.Close()
 
+	dateTaken, gpsLatitude, gpsLongitude, gpsAltitude := exifNullColumns(photo.Exif)
+
 	id := uuid.New()
 	_, err = stmt.Exec(
 		id.String(),
-		photo.ImageURL,
-		photo.ThumbnailURL,
+		photo.ImageKey,
+		photo.ThumbnailKey,
 		photo.ThumbWidth,
 		photo.ThumbHeight,
 		photo.Exif.Aperture,
 		photo.Exif.ShutterSpeed,
 		photo.Exif.ISO,
 		photo.CreatedAt,
+		photo.Exif.CameraMake,
+		photo.Exif.CameraModel,
+		photo.Exif.LensModel,
+		photo.Exif.FocalLength,
+		photo.Exif.FocalLength35mm,
+		photo.Exif.ExposureCompensation,
+		photo.Exif.Flash,
+		dateTaken,
+		photo.Exif.DateTakenOffset,
+		gpsLatitude,
+		gpsLongitude,
+		gpsAltitude,
+		photo.Title,
+		photo.Caption,
+		photo.AltText,
+		photo.BlurHash,
+		photo.DominantColor,
+		phashColumn(photo.PerceptualHash),
 	)
 	if err != nil {
 		return "", err
@@ -54,36 +90,21 @@ func CreatePhoto(db *sql.DB, photo *models.Photo) (string, error) {
 	// 	return 0, err
 	// }
 
-	for _, tag := range photo.Tags {
-		var tagID int64
-		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag.Name).Scan(&tagID)
-		if err == sql.ErrNoRows {
-			// Tag doesn't exist, so create it
-			tagStmt, err := tx.Prepare("INSERT INTO tags (name) VALUES (?)")
-			if err != nil {
-				return "", err // Can't prepare the statement
-			}
-			// Defer close inside the loop for safety
-			defer tagStmt.Close()
-
-			tagRes, err := tagStmt.Exec(tag.Name)
-			if err != nil {
-				return "", err
-			}
+	if err := linkTags(tx, id.String(), photo.Tags); err != nil {
+		return "", err
+	}
 
-			tagID, err = tagRes.LastInsertId()
-			if err != nil {
-				return "", err
-			}
+	if err := insertRenditions(tx, id.String(), photo.Renditions); err != nil {
+		return "", err
+	}
 
-		} else if err != nil {
-			// A different, unexpected error occurred
-			return "", err
-		}
+	if err := insertPalette(tx, id.String(), photo.Palette); err != nil {
+		return "", err
+	}
 
-		// Now, link the photo and the tag
-		_, err = tx.Exec("INSERT INTO photo_tags (photo_id, tag_id) VALUES (?, ?)", id.String(), tagID)
-		if err != nil {
+	if job != nil {
+		job.PhotoID = id.String()
+		if err := updateUploadJob(tx, context.Background(), job); err != nil {
 			return "", err
 		}
 	}
@@ -95,28 +116,54 @@ func CreatePhoto(db *sql.DB, photo *models.Photo) (string, error) {
 	return id.String(), nil
 }
 
+// GetPhotoByID returns the photo or nil if it does not exist or is in the trash.
 func GetPhotoByID(db *sql.DB, id string) (*models.Photo, error) {
 	// SQL to get all the information of the Photo
 	selectPhotoSQL := `
-		SELECT id, image_url, thumbnail_url, aperture, shutter_speed, iso, created_at
+		SELECT id, image_key, thumbnail_key, thumbnail_width, thumbnail_height, aperture, shutter_speed, iso, created_at,
+			camera_make, camera_model, lens_model, focal_length, focal_length_35mm, exposure_compensation, flash,
+			date_taken, date_taken_offset, gps_latitude, gps_longitude, gps_altitude,
+			title, caption, alt_text, updated_at, blur_hash, dominant_color
 		FROM photos
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
 	// query the db and store it in row
 	row := db.QueryRow(selectPhotoSQL, id)
 
 	// placeholder to hold the returned rows
 	var photo models.Photo
+	var dateTaken, updatedAt sql.NullTime
+	var gpsLatitude, gpsLongitude, gpsAltitude sql.NullFloat64
 
 	// put the row that we got back from the db into the above placeholder
 	err := row.Scan(
 		&photo.ID,
-		&photo.ImageURL,
-		&photo.ThumbnailURL,
+		&photo.ImageKey,
+		&photo.ThumbnailKey,
+		&photo.ThumbWidth,
+		&photo.ThumbHeight,
 		&photo.Exif.Aperture,
 		&photo.Exif.ShutterSpeed,
 		&photo.Exif.ISO,
 		&photo.CreatedAt,
+		&photo.Exif.CameraMake,
+		&photo.Exif.CameraModel,
+		&photo.Exif.LensModel,
+		&photo.Exif.FocalLength,
+		&photo.Exif.FocalLength35mm,
+		&photo.Exif.ExposureCompensation,
+		&photo.Exif.Flash,
+		&dateTaken,
+		&photo.Exif.DateTakenOffset,
+		&gpsLatitude,
+		&gpsLongitude,
+		&gpsAltitude,
+		&photo.Title,
+		&photo.Caption,
+		&photo.AltText,
+		&updatedAt,
+		&photo.BlurHash,
+		&photo.DominantColor,
 	)
 	// if sql returns a ErrNoRows variable meaning no rows exist
 	if err == sql.ErrNoRows {
@@ -125,6 +172,31 @@ func GetPhotoByID(db *sql.DB, id string) (*models.Photo, error) {
 		return nil, err
 	}
 
+	photo.Exif.DateTaken = localDateTaken(dateTaken, photo.Exif.DateTakenOffset)
+
+	renditions, err := GetRenditions(db, context.Background(), []string{photo.ID})
+	if err != nil {
+		return nil, err
+	}
+	photo.Renditions = renditions[photo.ID]
+
+	if photo.Palette, err = getPalette(db, context.Background(), photo.ID); err != nil {
+		return nil, err
+	}
+
+	if updatedAt.Valid {
+		photo.UpdatedAt = &updatedAt.Time
+	}
+	if gpsLatitude.Valid && gpsLongitude.Valid {
+		photo.Exif.GPS = &models.GPS{
+			Latitude:  gpsLatitude.Float64,
+			Longitude: gpsLongitude.Float64,
+		}
+		if gpsAltitude.Valid {
+			photo.Exif.GPS.Altitude = &gpsAltitude.Float64
+		}
+	}
+
 	// join the two tables, photo_tags and tags with the common row (tag_id) so that we can get all the tags for the specific photo
 	selectTagsSQL := `SELECT t.id, t.name FROM tags t INNER JOIN photo_tags pt ON t.id = pt.tag_id WHERE pt.photo_id = ?`
 
@@ -160,46 +232,172 @@ func GetPhotoByID(db *sql.DB, id string) (*models.Photo, error) {
 	return &photo, nil
 }
 
-func GetAllPhotos(db *sql.DB, createdAt time.Time, id string, LIMIT int) (PhotosResponse, error) {
+// PhotoFilter narrows the public feed. Zero values mean "no restriction".
+type PhotoFilter struct {
+	Tags           []string
+	MatchAllTags   bool
+	Camera         string
+	Lens           string
+	MinISO         *int
+	MaxISO         *int
+	MinAperture    *float64
+	MaxAperture    *float64
+	MinFocalLength *float64
+	MaxFocalLength *float64
+	TakenAfter     *time.Time
+	TakenBefore    *time.Time
+	// Color keeps photos with a palette colour close to it
+	Color *ColorQuery
+}
 
-	var response PhotosResponse
-	var rows *sql.Rows
-	var err error
-	var selectAllPhotos string
-	if createdAt.IsZero() {
-		selectAllPhotos = `
-		SELECT id, thumbnail_url, thumbnail_width, thumbnail_height, created_at
-		FROM Photos
-		ORDER BY created_at DESC
-		LIMIT ?`
-		rows, err = db.Query(selectAllPhotos, LIMIT)
-		if err != nil {
-			return PhotosResponse{}, err
+// ColorQuery is a colour in CIELAB and the largest delta E a palette colour may be away from it.
+type ColorQuery struct {
+	L, A, B     float64
+	MaxDistance float64
+}
+
+// likeEscaper escapes the LIKE wildcards in user input, so "50_mm" or "100%" match literally.
+// The queries declare the backslash with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// whereClauses turns the filter into SQL conditions on the photos table (aliased p) and their arguments.
+func (f PhotoFilter) whereClauses() ([]string, []any) {
+	// photos in the trash are never part of the public feed
+	clauses := []string{"p.deleted_at IS NULL"}
+	var args []any
+
+	if len(f.Tags) > 0 {
+		seen := make(map[string]bool, len(f.Tags))
+		var names []any
+		var placeholders []string
+		for _, tag := range f.Tags {
+			name := strings.ToLower(strings.TrimSpace(tag))
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+			placeholders = append(placeholders, "?")
 		}
-		defer rows.Close()
-	} else {
-		selectAllPhotos = `
-		SELECT id, thumbnail_url, thumbnail_width, thumbnail_height, created_at
-		FROM Photos
-		WHERE (created_at < ?) OR (created_at = ? AND id < ?)
-		ORDER BY created_at DESC, id DESC
-		LIMIT ?`
-		rows, err = db.Query(selectAllPhotos, createdAt, createdAt, id, LIMIT)
-		if err != nil {
-			return PhotosResponse{}, err
+
+		if len(names) > 0 {
+			tagSubquery := fmt.Sprintf(`
+			p.id IN (
+				SELECT pt.photo_id
+				FROM photo_tags pt
+				INNER JOIN tags t ON t.id = pt.tag_id
+				WHERE LOWER(t.name) IN (%s)`, strings.Join(placeholders, ","))
+			args = append(args, names...)
+
+			// "all" means the photo carries every requested tag, not just one of them
+			if f.MatchAllTags {
+				tagSubquery += `
+				GROUP BY pt.photo_id
+				HAVING COUNT(DISTINCT LOWER(t.name)) = ?`
+				args = append(args, len(names))
+			}
+			clauses = append(clauses, tagSubquery+")")
 		}
-		defer rows.Close()
 	}
 
+	if f.Camera != "" {
+		clauses = append(clauses, `(p.camera_make || ' ' || p.camera_model) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Camera)+"%")
+	}
+	if f.Lens != "" {
+		clauses = append(clauses, `p.lens_model LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Lens)+"%")
+	}
+
+	// iso and aperture are stored as the display strings ("100", "f/2.8"), so cast them for comparisons
+	isoValue := "CAST(NULLIF(TRIM(p.iso), '') AS INTEGER)"
+	apertureValue := "CAST(NULLIF(REPLACE(LOWER(TRIM(p.aperture)), 'f/', ''), '') AS REAL)"
+
+	if f.MinISO != nil {
+		clauses = append(clauses, isoValue+" >= ?")
+		args = append(args, *f.MinISO)
+	}
+	if f.MaxISO != nil {
+		clauses = append(clauses, isoValue+" <= ?")
+		args = append(args, *f.MaxISO)
+	}
+	if f.MinAperture != nil {
+		clauses = append(clauses, apertureValue+" >= ?")
+		args = append(args, *f.MinAperture)
+	}
+	if f.MaxAperture != nil {
+		clauses = append(clauses, apertureValue+" <= ?")
+		args = append(args, *f.MaxAperture)
+	}
+	if f.MinFocalLength != nil {
+		clauses = append(clauses, "p.focal_length > 0 AND p.focal_length >= ?")
+		args = append(args, *f.MinFocalLength)
+	}
+	if f.MaxFocalLength != nil {
+		clauses = append(clauses, "p.focal_length > 0 AND p.focal_length <= ?")
+		args = append(args, *f.MaxFocalLength)
+	}
+	if f.TakenAfter != nil {
+		clauses = append(clauses, "p.date_taken >= ?")
+		args = append(args, f.TakenAfter.UTC())
+	}
+	if f.TakenBefore != nil {
+		clauses = append(clauses, "p.date_taken < ?")
+		args = append(args, f.TakenBefore.UTC())
+	}
+	if f.Color != nil {
+		// squared distances, so SQLite needs no sqrt
+		clauses = append(clauses, `
+			p.id IN (
+				SELECT pp.photo_id
+				FROM photo_palette pp
+				WHERE (pp.lab_l - ?) * (pp.lab_l - ?) + (pp.lab_a - ?) * (pp.lab_a - ?) + (pp.lab_b - ?) * (pp.lab_b - ?) <= ?
+			)`)
+		args = append(args, f.Color.L, f.Color.L, f.Color.A, f.Color.A, f.Color.B, f.Color.B, f.Color.MaxDistance*f.Color.MaxDistance)
+	}
+
+	return clauses, args
+}
+
+func GetAllPhotos(db *sql.DB, createdAt time.Time, id string, LIMIT int, filter PhotoFilter) (PhotosResponse, error) {
+
+	var response PhotosResponse
+
+	whereClauses, args := filter.whereClauses()
+
+	// keyset pagination on (created_at, id) so new uploads never shift the pages
+	if !createdAt.IsZero() {
+		whereClauses = append(whereClauses, "((p.created_at < ?) OR (p.created_at = ? AND p.id < ?))")
+		args = append(args, createdAt, createdAt, id)
+	}
+
+	whereSQL := "WHERE " + strings.Join(whereClauses, " AND ")
+
+	selectAllPhotos := fmt.Sprintf(`
+		SELECT p.id, p.thumbnail_key, p.thumbnail_width, p.thumbnail_height, p.blur_hash, p.dominant_color, p.created_at
+		FROM photos p
+		%s
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT ?`, whereSQL)
+	args = append(args, LIMIT)
+
+	rows, err := db.Query(selectAllPhotos, args...)
+	if err != nil {
+		return PhotosResponse{}, err
+	}
+	defer rows.Close()
+
 	photoSlice := make([]models.ThumbnailPhoto, 0, LIMIT)
 
 	for rows.Next() {
 		var photoThumbnail models.ThumbnailPhoto
 		err := rows.Scan(
 			&photoThumbnail.ID,
-			&photoThumbnail.ThumbnailURL,
+			&photoThumbnail.ThumbnailKey,
 			&photoThumbnail.ThumbWidth,
 			&photoThumbnail.ThumbHeight,
+			&photoThumbnail.BlurHash,
+			&photoThumbnail.DominantColor,
 			&photoThumbnail.CreatedAt,
 		)
 		if err != nil {
@@ -212,6 +410,11 @@ func GetAllPhotos(db *sql.DB, createdAt time.Time, id string, LIMIT int) (Photos
 	if err := rows.Err(); err != nil {
 		return PhotosResponse{}, err
 	}
+	rows.Close()
+
+	if err := attachRenditions(db, context.Background(), photoSlice); err != nil {
+		return PhotosResponse{}, err
+	}
 
 	response.Photos = photoSlice
 
@@ -229,43 +432,32 @@ func GetAllPhotos(db *sql.DB, createdAt time.Time, id string, LIMIT int) (Photos
 
 }
 
-func GetAllPhotoIDs(db *sql.DB, ctx context.Context) ([]string, error) {
-	rows, err := db.QueryContext(ctx, `SELECT id FROM photos`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var ids []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return nil, err
-		}
-		ids = append(ids, id)
-	}
-	return ids, rows.Err()
-}
-
 func AddToFailedStore(db *sql.DB, ctx context.Context, failedList []models.Photo) error {
 	if len((failedList)) == 0 {
 		return fmt.Errorf("Empty failed list array")
 	}
 
 	placeholders := make([]string, len(failedList))
-	args := make([]any, 0, len(failedList)*3)
+	args := make([]any, 0, len(failedList)*5)
+	now := time.Now().UTC()
 
+	// new rows have no next_attempt_at so the retry worker picks them up on its next pass
 	for i, photo := range failedList {
-		placeholders[i] = "(?, ?, ?)"
-		args = append(args, photo.ID, photo.ImageURL, photo.ThumbnailURL)
+		extraKeys, err := encodeExtraKeys(photo)
+		if err != nil {
+			return err
+		}
+		placeholders[i] = "(?, ?, ?, ?, ?)"
+		args = append(args, photo.ID, photo.ImageKey, photo.ThumbnailKey, extraKeys, now)
 	}
 
 	query := fmt.Sprintf(`
-	INSERT INTO failed_storage_deletes (id, web_url, thumbnail_url)
+	INSERT INTO failed_storage_deletes (id, web_key, thumbnail_key, extra_keys, created_at)
 	VALUES %s
 	ON CONFLICT(id) DO UPDATE SET
-		web_url = excluded.web_url,
-		thumbnail_url = excluded.thumbnail_url;
+		web_key = excluded.web_key,
+		thumbnail_key = excluded.thumbnail_key,
+		extra_keys = excluded.extra_keys;
 	`, strings.Join(placeholders, ","))
 
 	_, err := db.ExecContext(ctx, query, args...)
@@ -275,3 +467,117 @@ func AddToFailedStore(db *sql.DB, ctx context.Context, failedList []models.Photo
 
 	return nil
 }
+
+// exifNullColumns converts the optional EXIF values into their nullable column values.
+// date_taken is stored in UTC so it sorts correctly, the offset column restores local time.
+func exifNullColumns(exif models.Exif) (dateTaken sql.NullTime, gpsLatitude, gpsLongitude, gpsAltitude sql.NullFloat64) {
+	if exif.DateTaken != nil {
+		dateTaken = sql.NullTime{Time: exif.DateTaken.UTC(), Valid: true}
+	}
+
+	if exif.GPS != nil {
+		gpsLatitude = sql.NullFloat64{Float64: exif.GPS.Latitude, Valid: true}
+		gpsLongitude = sql.NullFloat64{Float64: exif.GPS.Longitude, Valid: true}
+		if exif.GPS.Altitude != nil {
+			gpsAltitude = sql.NullFloat64{Float64: *exif.GPS.Altitude, Valid: true}
+		}
+	}
+
+	return dateTaken, gpsLatitude, gpsLongitude, gpsAltitude
+}
+
+// localDateTaken converts the stored UTC capture time back into the timezone the camera recorded.
+func localDateTaken(dateTaken sql.NullTime, offset string) *time.Time {
+	if !dateTaken.Valid {
+		return nil
+	}
+
+	taken := dateTaken.Time.UTC()
+	if offset != "" {
+		if zoned, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := zoned.Zone()
+			taken = taken.In(time.FixedZone("", seconds))
+		}
+	}
+
+	return &taken
+}
+
+// PhotoUpdate holds the editable fields of a photo. Nil fields are left untouched,
+// Tags replaces the full tag list and Exif replaces every stored EXIF column.
+type PhotoUpdate struct {
+	Title   *string
+	Caption *string
+	AltText *string
+	Tags    *[]models.Tag
+	Exif    *models.Exif
+}
+
+// UpdatePhoto applies update to the photo in one transaction and cleans up tags that lost their last photo.
+// It returns sql.ErrNoRows when no photo has the given id.
+func UpdatePhoto(db *sql.DB, ctx context.Context, id string, update PhotoUpdate) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	setClauses := []string{"updated_at = ?"}
+	args := []any{time.Now()}
+
+	if update.Title != nil {
+		setClauses = append(setClauses, "title = ?")
+		args = append(args, *update.Title)
+	}
+	if update.Caption != nil {
+		setClauses = append(setClauses, "caption = ?")
+		args = append(args, *update.Caption)
+	}
+	if update.AltText != nil {
+		setClauses = append(setClauses, "alt_text = ?")
+		args = append(args, *update.AltText)
+	}
+	if update.Exif != nil {
+		exif := *update.Exif
+		dateTaken, gpsLatitude, gpsLongitude, gpsAltitude := exifNullColumns(exif)
+		setClauses = append(setClauses,
+			"aperture = ?", "shutter_speed = ?", "iso = ?",
+			"camera_make = ?", "camera_model = ?", "lens_model = ?",
+			"focal_length = ?", "focal_length_35mm = ?", "exposure_compensation = ?", "flash = ?",
+			"date_taken = ?", "date_taken_offset = ?",
+			"gps_latitude = ?", "gps_longitude = ?", "gps_altitude = ?",
+		)
+		args = append(args,
+			exif.Aperture, exif.ShutterSpeed, exif.ISO,
+			exif.CameraMake, exif.CameraModel, exif.LensModel,
+			exif.FocalLength, exif.FocalLength35mm, exif.ExposureCompensation, exif.Flash,
+			dateTaken, exif.DateTakenOffset,
+			gpsLatitude, gpsLongitude, gpsAltitude,
+		)
+	}
+
+	args = append(args, id)
+	updatePhotoSQL := fmt.Sprintf("UPDATE photos SET %s WHERE id = ?", strings.Join(setClauses, ", "))
+
+	res, err := tx.ExecContext(ctx, updatePhotoSQL, args...)
+	if err != nil {
+		return err
+	}
+	if rowsUpdated, _ := res.RowsAffected(); rowsUpdated == 0 {
+		return sql.ErrNoRows
+	}
+
+	if update.Tags != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM photo_tags WHERE photo_id = ?", id); err != nil {
+			return err
+		}
+		if err := linkTags(tx, id, *update.Tags); err != nil {
+			return err
+		}
+		if err := DeleteOrphanTags(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFilterEscapesLikeWildcards(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	_, err := db.Exec(`INSERT INTO photos (id, image_key, thumbnail_key, camera_make, camera_model, lens_model) VALUES
+		('a', 'a.jpg', 'a_thumb.jpg', 'Canon', 'EOS R5', 'RF 50mm F1.8'),
+		('b', 'b.jpg', 'b_thumb.jpg', 'Canon', 'EOS 100%', 'RF_50mm'),
+		('c', 'c.jpg', 'c_thumb.jpg', 'Nikon', 'Z6', 'NIKKOR Z 50mm f/1.8 \ S')`)
+	if err != nil {
+		t.Fatalf("insert photos: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter PhotoFilter
+		want   int
+	}{
+		{"plain lens", PhotoFilter{Lens: "50mm"}, 3},
+		{"underscore is literal", PhotoFilter{Lens: "RF_50"}, 1},
+		{"percent is literal", PhotoFilter{Camera: "100%"}, 1},
+		{"percent alone", PhotoFilter{Camera: "%"}, 1},
+		{"backslash is literal", PhotoFilter{Lens: `\ S`}, 1},
+		{"camera make and model", PhotoFilter{Camera: "canon eos r5"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clauses, args := tt.filter.whereClauses()
+			var got int
+			query := "SELECT COUNT(*) FROM photos p WHERE " + strings.Join(clauses, " AND ")
+			if err := db.QueryRow(query, args...).Scan(&got); err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matched %d photos, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterByPaletteColour(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// distances from (50, 70, 50): exact 0, close 5, far 10, second 0 through its second colour
+	_, err := db.Exec(`
+		INSERT INTO photos (id, image_key, thumbnail_key) VALUES
+			('exact', 'e.jpg', 'e_thumb.jpg'),
+			('close', 'c.jpg', 'c_thumb.jpg'),
+			('far', 'f.jpg', 'f_thumb.jpg'),
+			('second', 's.jpg', 's_thumb.jpg'),
+			('none', 'n.jpg', 'n_thumb.jpg');
+		INSERT INTO photo_palette (photo_id, position, color, weight, lab_l, lab_a, lab_b) VALUES
+			('exact', 0, '#c81e1e', 1, 50, 70, 50),
+			('close', 0, '#cc2020', 1, 53, 70, 54),
+			('far', 0, '#d02828', 1, 56, 70, 58),
+			('second', 0, '#1428b4', 0.7, 30, 60, -100),
+			('second', 1, '#c81e1e', 0.3, 50, 70, 50);`)
+	if err != nil {
+		t.Fatalf("insert fixtures: %v", err)
+	}
+
+	tests := []struct {
+		maxDistance float64
+		want        []string
+	}{
+		{4.9, []string{"exact", "second"}},
+		{5, []string{"close", "exact", "second"}},
+		{10, []string{"close", "exact", "far", "second"}},
+	}
+	for _, tt := range tests {
+		filter := PhotoFilter{Color: &ColorQuery{L: 50, A: 70, B: 50, MaxDistance: tt.maxDistance}}
+		clauses, args := filter.whereClauses()
+		rows, err := db.Query("SELECT p.id FROM photos p WHERE "+strings.Join(clauses, " AND ")+" ORDER BY p.id", args...)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		var got []string
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			got = append(got, id)
+		}
+		rows.Close()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("max distance %v matched %v, want %v", tt.maxDistance, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PhotoFiles holds every storage key a photo row points at.
+type PhotoFiles struct {
+	ID            string
+	ImageKey      string
+	ThumbnailKey  string
+	RenditionKeys []string
+	Trashed       bool
+}
+
+// GetAllPhotoFiles returns the storage keys of every photo, including the ones in the trash.
+func GetAllPhotoFiles(db *sql.DB, ctx context.Context) ([]PhotoFiles, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, image_key, thumbnail_key, deleted_at IS NOT NULL
+	FROM photos
+	ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photoFiles []PhotoFiles
+	for rows.Next() {
+		var pf PhotoFiles
+		if err := rows.Scan(&pf.ID, &pf.ImageKey, &pf.ThumbnailKey, &pf.Trashed); err != nil {
+			return nil, err
+		}
+		photoFiles = append(photoFiles, pf)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	renditionRows, err := db.QueryContext(ctx, `SELECT photo_id, storage_key FROM photo_renditions`)
+	if err != nil {
+		return nil, err
+	}
+	defer renditionRows.Close()
+
+	renditionKeys := make(map[string][]string)
+	for renditionRows.Next() {
+		var photoID, key string
+		if err := renditionRows.Scan(&photoID, &key); err != nil {
+			return nil, err
+		}
+		renditionKeys[photoID] = append(renditionKeys[photoID], key)
+	}
+	if err := renditionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range photoFiles {
+		photoFiles[i].RenditionKeys = renditionKeys[photoFiles[i].ID]
+	}
+	return photoFiles, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+)
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func insertRenditions(tx *sql.Tx, photoID string, renditions []models.Rendition) error {
+	if len(renditions) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(renditions))
+	args := make([]any, 0, len(renditions)*5)
+	for i, rendition := range renditions {
+		placeholders[i] = "(?, ?, ?, ?, ?)"
+		args = append(args, photoID, rendition.Key, rendition.Width, rendition.Height, rendition.Format)
+	}
+
+	insertRenditionsSQL := fmt.Sprintf(`
+	INSERT INTO photo_renditions (photo_id, storage_key, width, height, format)
+	VALUES %s`, strings.Join(placeholders, ","))
+
+	if _, err := tx.Exec(insertRenditionsSQL, args...); err != nil {
+		return fmt.Errorf("could not insert renditions: %w", err)
+	}
+	return nil
+}
+
+// GetRenditions returns the renditions of every photo in photoIDs, smallest first.
+func GetRenditions(db queryer, ctx context.Context, photoIDs []string) (map[string][]models.Rendition, error) {
+	renditions := make(map[string][]models.Rendition, len(photoIDs))
+	if len(photoIDs) == 0 {
+		return renditions, nil
+	}
+
+	placeholders := make([]string, len(photoIDs))
+	args := make([]any, len(photoIDs))
+	for i, id := range photoIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	selectRenditions := fmt.Sprintf(`
+	SELECT photo_id, storage_key, width, height, format
+	FROM photo_renditions
+	WHERE photo_id IN (%s)
+	ORDER BY photo_id, width, format`, strings.Join(placeholders, ","))
+
+	rows, err := db.QueryContext(ctx, selectRenditions, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var photoID string
+		var rendition models.Rendition
+		if err := rows.Scan(&photoID, &rendition.Key, &rendition.Width, &rendition.Height, &rendition.Format); err != nil {
+			return nil, err
+		}
+		renditions[photoID] = append(renditions[photoID], rendition)
+	}
+
+	return renditions, rows.Err()
+}
+
+// attachRenditions fills Renditions on every thumbnail with a single query.
+func attachRenditions(db queryer, ctx context.Context, photos []models.ThumbnailPhoto) error {
+	ids := make([]string, len(photos))
+	for i, photo := range photos {
+		ids[i] = photo.ID
+	}
+
+	renditions, err := GetRenditions(db, ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range photos {
+		photos[i].Renditions = renditions[photos[i].ID]
+	}
+	return nil
+}
+
+// attachCoverRenditions fills the renditions of every album cover with a single query.
+func attachCoverRenditions(db queryer, ctx context.Context, albums []models.Album) error {
+	var ids []string
+	for _, album := range albums {
+		if album.CoverPhoto != nil {
+			ids = append(ids, album.CoverPhoto.ID)
+		}
+	}
+
+	renditions, err := GetRenditions(db, ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range albums {
+		if albums[i].CoverPhoto != nil {
+			albums[i].CoverPhoto.Renditions = renditions[albums[i].CoverPhoto.ID]
+		}
+	}
+	return nil
+}
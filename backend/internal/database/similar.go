@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+)
+
+// PhotoHash is the perceptual hash of a photo outside the trash.
+type PhotoHash struct {
+	ID   string
+	Hash uint64
+}
+
+// phashColumn stores the unsigned hash as its signed bit pattern, SQLite integers are signed.
+func phashColumn(hash *uint64) sql.NullInt64 {
+	if hash == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*hash), Valid: true}
+}
+
+// GetPhotoHashes returns the hash of every photo outside the trash that has one. Hamming distances
+// cannot be computed in SQLite, so callers compare them in Go; a hash is 8 bytes per photo.
+func GetPhotoHashes(db *sql.DB, ctx context.Context) ([]PhotoHash, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, phash FROM photos WHERE phash IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []PhotoHash
+	for rows.Next() {
+		var id string
+		var hash int64
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, PhotoHash{ID: id, Hash: uint64(hash)})
+	}
+	return hashes, rows.Err()
+}
+
+// GetPhotoHash returns the hash of a photo, nil if it was uploaded before hashes existed.
+// A photo that does not exist or is in the trash returns sql.ErrNoRows.
+func GetPhotoHash(db *sql.DB, ctx context.Context, id string) (*uint64, error) {
+	var hash sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT phash FROM photos WHERE id = ? AND deleted_at IS NULL`, id).Scan(&hash)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Valid {
+		return nil, nil
+	}
+
+	value := uint64(hash.Int64)
+	return &value, nil
+}
+
+// GetThumbnailPhotos returns the photos among ids that are outside the trash, keyed by id.
+func GetThumbnailPhotos(db *sql.DB, ctx context.Context, ids []string) (map[string]models.ThumbnailPhoto, error) {
+	photos := make(map[string]models.ThumbnailPhoto, len(ids))
+	if len(ids) == 0 {
+		return photos, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	selectThumbnails := fmt.Sprintf(`
+	SELECT id, thumbnail_key, thumbnail_width, thumbnail_height, blur_hash, dominant_color, created_at
+	FROM photos
+	WHERE id IN (%s) AND deleted_at IS NULL`, strings.Join(placeholders, ","))
+
+	rows, err := db.QueryContext(ctx, selectThumbnails, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.ThumbnailPhoto
+	for rows.Next() {
+		var photo models.ThumbnailPhoto
+		if err := rows.Scan(
+			&photo.ID,
+			&photo.ThumbnailKey,
+			&photo.ThumbWidth,
+			&photo.ThumbHeight,
+			&photo.BlurHash,
+			&photo.DominantColor,
+			&photo.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		list = append(list, photo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachRenditions(db, ctx, list); err != nil {
+		return nil, err
+	}
+	for _, photo := range list {
+		photos[photo.ID] = photo
+	}
+	return photos, nil
+}
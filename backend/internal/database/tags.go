@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"shutterdev/backend/internal/models"
+)
+
+// ErrTagNameTaken is returned when a rename would collide with another existing tag.
+var ErrTagNameTaken = errors.New("another tag already uses this name")
+
+// linkTags attaches every tag to the photo, creating tags that do not exist yet.
+// Blank and repeated names are skipped so "street, street" does not violate the photo_tags primary key.
+func linkTags(tx *sql.Tx, photoID string, tags []models.Tag) error {
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		name := strings.TrimSpace(tag.Name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		var tagID int64
+		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&tagID)
+		if err == sql.ErrNoRows {
+			// Tag doesn't exist, so create it
+			tagRes, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", name)
+			if err != nil {
+				return err
+			}
+
+			tagID, err = tagRes.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+		} else if err != nil {
+			// A different, unexpected error occurred
+			return err
+		}
+
+		// Now, link the photo and the tag
+		_, err = tx.Exec("INSERT INTO photo_tags (photo_id, tag_id) VALUES (?, ?)", photoID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteOrphanTags removes every tag that is no longer linked to any photo.
+func DeleteOrphanTags(ctx context.Context, tx *sql.Tx) error {
+	removeOrphanTags := `
+	DELETE FROM tags
+		WHERE id IN (
+			SELECT t.id
+			FROM tags t
+			WHERE NOT EXISTS (
+				SELECT 1
+				FROM photo_tags pt
+				WHERE pt.tag_id = t.id
+			)
+		);`
+
+	_, err := tx.ExecContext(ctx, removeOrphanTags)
+	return err
+}
+
+// GetAllTags returns every tag with the number of photos using it, most used first.
+// Photos in the trash are not counted. Tags that are only used by trashed photos are left out
+// unless includeUnused is set, the admin still needs to see them to rename or merge them.
+func GetAllTags(db *sql.DB, ctx context.Context, includeUnused bool) ([]models.TagWithCount, error) {
+	having := ""
+	if !includeUnused {
+		having = "HAVING photo_count > 0"
+	}
+
+	selectTagsSQL := fmt.Sprintf(`
+		SELECT t.id, t.name, COUNT(p.id) AS photo_count
+		FROM tags t
+		LEFT JOIN photo_tags pt ON pt.tag_id = t.id
+		LEFT JOIN photos p ON p.id = pt.photo_id AND p.deleted_at IS NULL
+		GROUP BY t.id, t.name
+		%s
+		ORDER BY photo_count DESC, t.name ASC`, having)
+
+	rows, err := db.QueryContext(ctx, selectTagsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]models.TagWithCount, 0)
+	for rows.Next() {
+		var tag models.TagWithCount
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.PhotoCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// RenameTag changes the name of a tag. It returns sql.ErrNoRows if the tag does not exist
+// and ErrTagNameTaken if a different tag already has newName, those should be merged instead.
+func RenameTag(db *sql.DB, ctx context.Context, id int, newName string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := renameTag(tx, ctx, id, newName); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeTags moves every photo tagged with one of sourceIDs over to targetID and deletes the source tags.
+// If newName is not empty the target is renamed in the same transaction.
+// It returns sql.ErrNoRows if the target or any of the sources do not exist.
+func MergeTags(db *sql.DB, ctx context.Context, sourceIDs []int, targetID int, newName string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a source listed twice must only be counted once when checking that all of them exist
+	var sources []int
+	seen := make(map[int]bool)
+	for _, sourceID := range sourceIDs {
+		if sourceID != targetID && !seen[sourceID] {
+			seen[sourceID] = true
+			sources = append(sources, sourceID)
+		}
+	}
+
+	placeholders := make([]string, len(sources))
+	args := make([]any, len(sources))
+	for i, sourceID := range sources {
+		placeholders[i] = "?"
+		args[i] = sourceID
+	}
+	inClause := strings.Join(placeholders, ",")
+
+	var found int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM tags WHERE id = ?", targetID).Scan(&found); err != nil {
+		return err
+	}
+	if found == 0 {
+		return sql.ErrNoRows
+	}
+
+	if len(sources) > 0 {
+		countSources := fmt.Sprintf("SELECT COUNT(*) FROM tags WHERE id IN (%s)", inClause)
+		if err := tx.QueryRowContext(ctx, countSources, args...).Scan(&found); err != nil {
+			return err
+		}
+		if found != len(sources) {
+			return sql.ErrNoRows
+		}
+
+		// photos that already carry the target keep a single link
+		relinkPhotos := fmt.Sprintf(`
+			INSERT OR IGNORE INTO photo_tags (photo_id, tag_id)
+			SELECT photo_id, ? FROM photo_tags WHERE tag_id IN (%s)`, inClause)
+		if _, err := tx.ExecContext(ctx, relinkPhotos, append([]any{targetID}, args...)...); err != nil {
+			return err
+		}
+
+		unlinkSources := fmt.Sprintf("DELETE FROM photo_tags WHERE tag_id IN (%s)", inClause)
+		if _, err := tx.ExecContext(ctx, unlinkSources, args...); err != nil {
+			return err
+		}
+
+		deleteSources := fmt.Sprintf("DELETE FROM tags WHERE id IN (%s)", inClause)
+		if _, err := tx.ExecContext(ctx, deleteSources, args...); err != nil {
+			return err
+		}
+	}
+
+	if newName != "" {
+		if err := renameTag(tx, ctx, targetID, newName); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTag removes a tag from every photo and deletes it. It returns sql.ErrNoRows if the tag does not exist.
+func DeleteTag(db *sql.DB, ctx context.Context, id int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM photo_tags WHERE tag_id = ?", id); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM tags WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if rowsDeleted, _ := res.RowsAffected(); rowsDeleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+func renameTag(tx *sql.Tx, ctx context.Context, id int, newName string) error {
+	var existingID int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM tags WHERE name = ?", newName).Scan(&existingID)
+	if err == nil && existingID != id {
+		return ErrTagNameTaken
+	} else if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE tags SET name = ? WHERE id = ?", newName, id)
+	if err != nil {
+		return err
+	}
+	if rowsUpdated, _ := res.RowsAffected(); rowsUpdated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
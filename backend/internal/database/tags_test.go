@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMergeTagsWithRepeatedSources(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO tags (id, name) VALUES (1, 'street'), (2, 'Street'), (3, 'streets')`); err != nil {
+		t.Fatalf("insert tags: %v", err)
+	}
+
+	if err := MergeTags(db, context.Background(), []int{2, 2, 3, 1}, 1, ""); err != nil {
+		t.Fatalf("MergeTags: %v", err)
+	}
+
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d tags left after the merge, want 1", left)
+	}
+}
+
+func TestGetAllTagsSkipsTagsOfTrashedPhotos(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO photos (id, image_key, thumbnail_key, deleted_at) VALUES
+			('visible', 'v.jpg', 'v_thumb.jpg', NULL),
+			('trashed', 't.jpg', 't_thumb.jpg', CURRENT_TIMESTAMP);
+		INSERT INTO tags (id, name) VALUES (1, 'kept'), (2, 'trashed only');
+		INSERT INTO photo_tags (photo_id, tag_id) VALUES ('visible', 1), ('trashed', 1), ('trashed', 2);`)
+	if err != nil {
+		t.Fatalf("insert fixtures: %v", err)
+	}
+
+	tags, err := GetAllTags(db, context.Background(), false)
+	if err != nil {
+		t.Fatalf("GetAllTags: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Name != "kept" || tags[0].PhotoCount != 1 {
+		t.Errorf("public tags = %+v, want only kept with 1 photo", tags)
+	}
+
+	tags, err = GetAllTags(db, context.Background(), true)
+	if err != nil {
+		t.Fatalf("GetAllTags: %v", err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("got %d tags including unused ones, want 2", len(tags))
+	}
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+	"time"
+)
+
+// TrashPhotos moves the photos to the trash. Photos already in the trash keep their original deleted_at.
+func TrashPhotos(db *sql.DB, ctx context.Context, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := []any{time.Now()}
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	trashPhotos := fmt.Sprintf(`
+	UPDATE photos SET deleted_at = ?
+	WHERE id IN (%s) AND deleted_at IS NULL`, strings.Join(placeholders, ","))
+
+	res, err := db.ExecContext(ctx, trashPhotos, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// TrashAllPhotos moves every photo that is not already in the trash to it.
+func TrashAllPhotos(db *sql.DB, ctx context.Context) (int64, error) {
+	res, err := db.ExecContext(ctx, `UPDATE photos SET deleted_at = ? WHERE deleted_at IS NULL`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RestorePhotos takes the photos back out of the trash.
+func RestorePhotos(db *sql.DB, ctx context.Context, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	restorePhotos := fmt.Sprintf(`
+	UPDATE photos SET deleted_at = NULL
+	WHERE id IN (%s) AND deleted_at IS NOT NULL`, strings.Join(placeholders, ","))
+
+	res, err := db.ExecContext(ctx, restorePhotos, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetTrashedPhotos lists the photos in the trash, most recently deleted first.
+func GetTrashedPhotos(db *sql.DB, ctx context.Context) ([]models.TrashedPhoto, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, thumbnail_key, thumbnail_width, thumbnail_height, created_at, deleted_at
+		FROM photos
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trashed := make([]models.TrashedPhoto, 0)
+	for rows.Next() {
+		var photo models.TrashedPhoto
+		err := rows.Scan(
+			&photo.ID,
+			&photo.ThumbnailKey,
+			&photo.ThumbWidth,
+			&photo.ThumbHeight,
+			&photo.CreatedAt,
+			&photo.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		trashed = append(trashed, photo)
+	}
+
+	return trashed, rows.Err()
+}
+
+// GetTrashedPhotoIDs returns the ids of photos in the trash. With a non-zero deletedBefore
+// only photos trashed before that time are returned, which is what the purge uses.
+// Passing ids restricts the result to those photos.
+func GetTrashedPhotoIDs(db *sql.DB, ctx context.Context, deletedBefore time.Time, ids []string) ([]string, error) {
+	query := `SELECT id FROM photos WHERE deleted_at IS NOT NULL`
+	var args []any
+
+	if !deletedBefore.IsZero() {
+		query += ` AND deleted_at < ?`
+		args = append(args, deletedBefore)
+	}
+	if len(ids) > 0 {
+		placeholders := make([]string, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		query += fmt.Sprintf(` AND id IN (%s)`, strings.Join(placeholders, ","))
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trashedIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		trashedIDs = append(trashedIDs, id)
+	}
+	return trashedIDs, rows.Err()
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"time"
+)
+
+const selectTusUploadsSQL = `
+	SELECT id, upload_length, metadata, spool_path, created_at, expires_at, completed_at
+	FROM tus_uploads`
+
+// CreateTusUpload stores a resumable upload that was just created.
+func CreateTusUpload(db *sql.DB, ctx context.Context, upload *models.TusUpload) error {
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO tus_uploads (id, upload_length, metadata, spool_path, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)`,
+		upload.ID, upload.Length, upload.Metadata, upload.SpoolPath, upload.CreatedAt.UTC(), upload.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("Failed to create tus upload (%s): %v", upload.ID, err)
+	}
+	return nil
+}
+
+// GetTusUpload returns an upload, or sql.ErrNoRows when there is none with that ID.
+func GetTusUpload(db *sql.DB, ctx context.Context, id string) (*models.TusUpload, error) {
+	uploads, err := queryTusUploads(db, ctx, selectTusUploadsSQL+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(uploads) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &uploads[0], nil
+}
+
+// GetExpiredTusUploads returns the uploads whose expiry passed at now, completed or not.
+func GetExpiredTusUploads(db *sql.DB, ctx context.Context, now time.Time) ([]models.TusUpload, error) {
+	return queryTusUploads(db, ctx, selectTusUploadsSQL+` WHERE expires_at < ?`, now.UTC())
+}
+
+func queryTusUploads(db *sql.DB, ctx context.Context, query string, args ...any) ([]models.TusUpload, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []models.TusUpload{}
+	for rows.Next() {
+		var upload models.TusUpload
+		var completedAt sql.NullTime
+		if err := rows.Scan(
+			&upload.ID,
+			&upload.Length,
+			&upload.Metadata,
+			&upload.SpoolPath,
+			&upload.CreatedAt,
+			&upload.ExpiresAt,
+			&completedAt,
+		); err != nil {
+			return nil, err
+		}
+		upload.CompletedAt = nullTimePtr(completedAt)
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+// ExtendTusUpload moves the expiry of an upload that received data.
+func ExtendTusUpload(db *sql.DB, ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := db.ExecContext(ctx, `UPDATE tus_uploads SET expires_at = ? WHERE id = ?`, expiresAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("Failed to extend tus upload (%s): %v", id, err)
+	}
+	return nil
+}
+
+// CompleteTusUploadWithJob marks an upload whose bytes all arrived as completed and stores the
+// job that processes it in the same transaction, so an upload handed to a job never looks unfinished.
+func CompleteTusUploadWithJob(db *sql.DB, ctx context.Context, id string, completedAt time.Time, job *models.UploadJob) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE tus_uploads SET completed_at = ? WHERE id = ?`, completedAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("Failed to complete tus upload (%s): %v", id, err)
+	}
+	if completed, _ := res.RowsAffected(); completed == 0 {
+		return fmt.Errorf("Failed to complete tus upload (%s): %w", id, sql.ErrNoRows)
+	}
+	if err := createUploadJob(tx, ctx, job); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTusUploads removes uploads by ID, their files are the caller's to remove.
+func DeleteTusUploads(db *sql.DB, ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		if _, err := db.ExecContext(ctx, `DELETE FROM tus_uploads WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("Failed to delete tus upload (%s): %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateAlbumRequest struct {
+	Title       string `json:"title"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+}
+
+type UpdateAlbumRequest struct {
+	Title        *string `json:"title"`
+	Slug         *string `json:"slug"`
+	Description  *string `json:"description"`
+	CoverPhotoID *string `json:"coverPhotoId"`
+}
+
+type AlbumPhotosRequest struct {
+	PhotoIDs []string `json:"photoIds"`
+}
+
+// GET /api/albums?cursor=x (x is base64 string of json)
+func (h *PhotoHandler) GetAllAlbums(c *gin.Context) {
+	const LIMIT = 10
+
+	var cursorObtained models.Cursor
+	if err := decodeCursor(c.Query("cursor"), &cursorObtained); err != nil {
+		log.Printf("[ALBUMS] Could not decode cursor - %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	albums, err := database.GetAllAlbums(h.DB, ctx, cursorObtained.CreatedAt, cursorObtained.ID, LIMIT)
+	if err != nil {
+		log.Printf("[ALBUMS:ERROR] Could not fetch albums - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch albums"})
+		return
+	}
+
+	for i := range albums.Albums {
+		h.withAlbumURLs(&albums.Albums[i])
+	}
+	c.JSON(http.StatusOK, albums)
+}
+
+// GET /api/albums/:slug?cursor=x (x is base64 string of json)
+func (h *PhotoHandler) GetAlbumBySlug(c *gin.Context) {
+	const LIMIT = 20
+
+	var cursor *models.AlbumCursor
+	if c.Query("cursor") != "" {
+		cursor = &models.AlbumCursor{}
+		if err := decodeCursor(c.Query("cursor"), cursor); err != nil {
+			log.Printf("[ALBUMS] Could not decode cursor - %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	album, err := database.GetAlbumPhotosBySlug(h.DB, ctx, c.Param("slug"), cursor, LIMIT)
+	if err != nil {
+		log.Printf("[ALBUMS:ERROR] Could not fetch album (%s) - %v", c.Param("slug"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch album"})
+		return
+	}
+	if album == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album Not Found"})
+		return
+	}
+
+	h.withAlbumURLs(&album.Album)
+	h.withThumbnailURLs(album.Photos)
+	c.JSON(http.StatusOK, album)
+}
+
+// POST /api/admin/albums
+func (h *PhotoHandler) CreateAlbum(c *gin.Context) {
+	var createRequest CreateAlbumRequest
+	if bindError := c.ShouldBindJSON(&createRequest); bindError != nil {
+		log.Printf("[ALBUMS:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	album := &models.Album{
+		Title:       strings.TrimSpace(createRequest.Title),
+		Slug:        createRequest.Slug,
+		Description: strings.TrimSpace(createRequest.Description),
+	}
+	if album.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Album title cannot be empty"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	id, err := database.CreateAlbum(h.DB, ctx, album)
+	if err != nil {
+		respondAlbumError(c, "create", err)
+		return
+	}
+
+	created, err := database.GetAlbumByID(h.DB, ctx, id)
+	if err != nil || created == nil {
+		log.Printf("[ALBUMS:ERROR] Could not fetch created album (%s) - %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch album"})
+		return
+	}
+
+	log.Printf("[ALBUMS] Created album %q (%s)", created.Title, created.Slug)
+	h.withAlbumURLs(created)
+	c.JSON(http.StatusCreated, created)
+}
+
+// PATCH /api/admin/albums/:id
+func (h *PhotoHandler) UpdateAlbum(c *gin.Context) {
+	albumID := c.Param("id")
+
+	var updateRequest UpdateAlbumRequest
+	if bindError := c.ShouldBindJSON(&updateRequest); bindError != nil {
+		log.Printf("[ALBUMS:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	update := database.AlbumUpdate{
+		Title:        trimmedPtr(updateRequest.Title),
+		Description:  trimmedPtr(updateRequest.Description),
+		CoverPhotoID: trimmedPtr(updateRequest.CoverPhotoID),
+	}
+	if update.Title != nil && *update.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Album title cannot be empty"})
+		return
+	}
+	if updateRequest.Slug != nil {
+		slug := database.Slugify(*updateRequest.Slug)
+		if slug == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Album slug must contain letters or digits"})
+			return
+		}
+		update.Slug = &slug
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := database.UpdateAlbum(h.DB, ctx, albumID, update); err != nil {
+		respondAlbumError(c, "update", err)
+		return
+	}
+
+	h.respondWithAlbum(c, ctx, albumID)
+}
+
+// DELETE /api/admin/albums/:id
+func (h *PhotoHandler) DeleteAlbum(c *gin.Context) {
+	albumID := c.Param("id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := database.DeleteAlbum(h.DB, ctx, albumID); err != nil {
+		respondAlbumError(c, "delete", err)
+		return
+	}
+
+	log.Printf("[ALBUMS] Deleted album (%s)", albumID)
+	c.JSON(http.StatusOK, gin.H{"message": "Album deleted"})
+}
+
+// POST /api/admin/albums/:id/photos
+func (h *PhotoHandler) AddPhotosToAlbum(c *gin.Context) {
+	albumID := c.Param("id")
+
+	var photosRequest AlbumPhotosRequest
+	if bindError := c.ShouldBindJSON(&photosRequest); bindError != nil || len(photosRequest.PhotoIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photoIds must contain at least one photo id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	added, err := database.AddPhotosToAlbum(h.DB, ctx, albumID, photosRequest.PhotoIDs)
+	if err != nil {
+		respondAlbumError(c, "add photos to", err)
+		return
+	}
+
+	log.Printf("[ALBUMS] Added %d photos to album (%s)", added, albumID)
+	h.respondWithAlbum(c, ctx, albumID)
+}
+
+// DELETE /api/admin/albums/:id/photos
+func (h *PhotoHandler) RemovePhotosFromAlbum(c *gin.Context) {
+	albumID := c.Param("id")
+
+	var photosRequest AlbumPhotosRequest
+	if bindError := c.ShouldBindJSON(&photosRequest); bindError != nil || len(photosRequest.PhotoIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photoIds must contain at least one photo id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	removed, err := database.RemovePhotosFromAlbum(h.DB, ctx, albumID, photosRequest.PhotoIDs)
+	if err != nil {
+		respondAlbumError(c, "remove photos from", err)
+		return
+	}
+
+	log.Printf("[ALBUMS] Removed %d photos from album (%s)", removed, albumID)
+	h.respondWithAlbum(c, ctx, albumID)
+}
+
+// PUT /api/admin/albums/:id/order
+func (h *PhotoHandler) ReorderAlbumPhotos(c *gin.Context) {
+	albumID := c.Param("id")
+
+	var photosRequest AlbumPhotosRequest
+	if bindError := c.ShouldBindJSON(&photosRequest); bindError != nil || len(photosRequest.PhotoIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photoIds must contain at least one photo id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := database.ReorderAlbumPhotos(h.DB, ctx, albumID, photosRequest.PhotoIDs); err != nil {
+		respondAlbumError(c, "reorder", err)
+		return
+	}
+
+	log.Printf("[ALBUMS] Reordered album (%s)", albumID)
+	h.respondWithAlbum(c, ctx, albumID)
+}
+
+func (h *PhotoHandler) respondWithAlbum(c *gin.Context, ctx context.Context, albumID string) {
+	album, err := database.GetAlbumByID(h.DB, ctx, albumID)
+	if err != nil {
+		log.Printf("[ALBUMS:ERROR] Could not fetch album (%s) - %v", albumID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch album"})
+		return
+	}
+	if album == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album Not Found"})
+		return
+	}
+
+	h.withAlbumURLs(album)
+	c.JSON(http.StatusOK, album)
+}
+
+func respondAlbumError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album Not Found"})
+	case errors.Is(err, database.ErrSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrPhotoNotFound), errors.Is(err, database.ErrPhotoNotInAlbum):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[ALBUMS:ERROR] Could not %s album - %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " album"})
+	}
+}
+
+// decodeCursor unpacks a base64 encoded JSON cursor into target. An empty cursor leaves target untouched.
+func decodeCursor(cursor string, target any) error {
+	if cursor == "" {
+		return nil
+	}
+
+	decodedCursor, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+	if len(decodedCursor) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(decodedCursor, target)
+}
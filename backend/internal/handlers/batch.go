@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxBatchFiles is how many images one batch upload may carry.
+const DefaultMaxBatchFiles = 20
+
+// POST /api/admin/photos/batch
+// Uploads every `image` part of the form. The fields of the i-th image are named title[i],
+// caption[i], altText[i], tags[i], exif[i] and allowDuplicate[i], a plain title or tags applies to
+// every image without its own. Images are processed concurrently within the processing pool and a
+// failed image does not stop the others: the response lists a result per image, in upload order.
+func (h *PhotoHandler) UploadPhotoBatch(c *gin.Context) {
+
+	t0 := time.Now()
+	if h.UploadPolicy.MaxBytes > 0 {
+		limit := int64(h.MaxBatchFiles) * (h.UploadPolicy.MaxBytes + multipartOverhead)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("[BATCH - FAILED] Upload body exceeds %d bytes", maxBytesErr.Limit)
+			respondUploadError(c, h.UploadPolicy.FileTooLarge())
+			return
+		}
+		log.Printf("[BATCH - ERROR] Could not parse multipart form - %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse multipart form"})
+		return
+	}
+
+	files := form.File["image"]
+	if len(files) == 0 {
+		log.Println("[BATCH - FAILED] No image provided in the request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no image provided"})
+		return
+	}
+	if len(files) > h.MaxBatchFiles {
+		log.Printf("[BATCH - FAILED] %d images sent, at most %d allowed", len(files), h.MaxBatchFiles)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d images allowed per batch", h.MaxBatchFiles)})
+		return
+	}
+
+	results := make([]gin.H, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	// no more workers than the pool runs at once, so a large batch does not fill the queue on its own
+	for range min(len(files), h.Pool.Concurrency()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.processBatchImage(c, form, i)
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	created, retryAfter := 0, 0
+	for _, result := range results {
+		if result["status"] == http.StatusCreated {
+			created++
+		}
+		if seconds, ok := result["retryAfter"].(int); ok {
+			retryAfter = max(retryAfter, seconds)
+		}
+	}
+
+	log.Printf("[BATCH] %d of %d images uploaded, took - [%v]", created, len(files), time.Since(t0))
+
+	// 207 tells the client to look at every result, some of them failed
+	status := http.StatusCreated
+	if created < len(files) {
+		status = http.StatusMultiStatus
+	}
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	c.JSON(status, gin.H{
+		"created": created,
+		"failed":  len(files) - created,
+		"results": results,
+	})
+}
+
+// processBatchImage uploads the i-th image of a batch and reports its result.
+func (h *PhotoHandler) processBatchImage(c *gin.Context, form *multipart.Form, i int) gin.H {
+	file := form.File["image"][i]
+
+	result, err := h.processSingleImage(c.Request.Context(), multipartSource(file), readUploadFields(form, fmt.Sprintf("[%d]", i)), nil)
+	if err != nil {
+		status, body := h.uploadErrorResponse(file.Filename, err)
+		body["index"] = i
+		body["fileName"] = file.Filename
+		body["status"] = status
+		return body
+	}
+
+	log.Printf("[%v - SUCCESS] Uploaded as part of a batch", file.Filename)
+	return gin.H{
+		"index":      i,
+		"fileName":   file.Filename,
+		"status":     http.StatusCreated,
+		"id":         result.ID,
+		"duplicates": result.Duplicates,
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteRetryPolicy is the backoff used for rows in failed_storage_deletes.
+// The n-th retry waits BaseDelay * 2^(n-1), capped at MaxDelay, with up to half of it randomised
+// so that a storage outage does not make every row retry at the same moment.
+type DeleteRetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+var DefaultDeleteRetryPolicy = DeleteRetryPolicy{
+	BaseDelay:   time.Minute,
+	MaxDelay:    6 * time.Hour,
+	MaxAttempts: 10,
+}
+
+// nextAttempt returns when a row that has failed attempts times should be tried again,
+// or nil once the policy gives up on it.
+func (p DeleteRetryPolicy) nextAttempt(attempts int, now time.Time) *time.Time {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return nil
+	}
+
+	delay := p.MaxDelay
+	if shift := max(attempts-1, 0); shift < 32 {
+		if backoff := p.BaseDelay << shift; backoff > 0 && backoff < p.MaxDelay {
+			delay = backoff
+		}
+	}
+
+	// equal jitter, wait between half and the full delay
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int64N(half))
+	}
+
+	next := now.Add(delay)
+	return &next
+}
+
+// GET /api/admin/photos/failed
+func (h *PhotoHandler) GetFailedDeletes(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	failedDeletes, err := database.GetFailedDeletes(h.DB, ctx)
+	if err != nil {
+		log.Printf("[RETRY:ERROR] Could not fetch the failed delete queue - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the failed delete queue"})
+		return
+	}
+
+	var pending, gaveUp int
+	for _, fd := range failedDeletes {
+		if fd.GaveUpAt != nil {
+			gaveUp++
+		} else {
+			pending++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pending":     pending,
+		"gaveUp":      gaveUp,
+		"maxAttempts": h.DeleteRetry.MaxAttempts,
+		"items":       failedDeletes,
+	})
+}
+
+// RunFailedDeleteRetrier retries due rows of failed_storage_deletes every interval until ctx is cancelled.
+func (h *PhotoHandler) RunFailedDeleteRetrier(ctx context.Context, interval time.Duration) {
+	const BatchSize = 50
+
+	log.Printf("[RETRY] Retrying failed storage deletes every %v (max %d attempts)", interval, h.DeleteRetry.MaxAttempts)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		passCtx, cancel := context.WithTimeout(ctx, interval)
+		retried, succeeded, err := h.retryFailedDeletes(passCtx, func(ctx context.Context) ([]models.FailedDelete, error) {
+			return database.GetDueFailedDeletes(h.DB, ctx, time.Now(), BatchSize)
+		})
+		if err != nil {
+			log.Printf("[RETRY:ERROR] %v", err)
+		}
+		if retried > 0 {
+			log.Printf("[RETRY] Retried %d failed deletes, %d succeeded", retried, succeeded)
+		}
+		cancel()
+	}
+}
+
+// retryFailedDeletes tries to delete the files of every row that fetch returns, removes the rows
+// that succeeded and schedules the next attempt for the rest. The rows are fetched under retryMu so
+// a row is never retried by the worker and the manual endpoint at once.
+// It returns how many rows were retried and how many of them succeeded.
+func (h *PhotoHandler) retryFailedDeletes(ctx context.Context, fetch func(context.Context) ([]models.FailedDelete, error)) (int, int, error) {
+	const AttemptTimeout = 15 * time.Second
+
+	// the worker and the manual endpoint must not count the same attempt twice
+	h.retryMu.Lock()
+	defer h.retryMu.Unlock()
+
+	retryList, err := fetch(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not fetch the failed deletes to retry - %v", err)
+	}
+
+	var successList []string
+	var recordErr error
+	for _, fd := range retryList {
+		if ctx.Err() != nil {
+			break
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, AttemptTimeout)
+		deleteErr := h.deleteBlobs(append([]string{fd.ImageKey, fd.ThumbnailKey}, fd.ExtraKeys...), attemptCtx)
+		cancel()
+
+		if deleteErr == nil {
+			successList = append(successList, fd.ID)
+			continue
+		}
+
+		nextAttemptAt := h.DeleteRetry.nextAttempt(fd.Attempts+1, time.Now())
+		if nextAttemptAt == nil {
+			log.Printf("[RETRY] Giving up on photo (%s) after %d attempts - %v", fd.ID, fd.Attempts+1, deleteErr)
+		} else {
+			log.Printf("[RETRY] Delete for photo (%s) failed, next attempt at %v - %v", fd.ID, nextAttemptAt.Format(time.RFC3339), deleteErr)
+		}
+
+		// keep going, the rows that already succeeded still have to be removed below
+		if err := database.RecordFailedDeleteAttempt(h.DB, ctx, fd.ID, deleteErr, nextAttemptAt); err != nil && recordErr == nil {
+			recordErr = err
+		}
+	}
+
+	if _, err := database.RemoveFailedDeletes(h.DB, ctx, successList); err != nil {
+		return len(retryList), len(successList), fmt.Errorf("Could not remove the succeeded rows from failed_storage_deletes - %v", err)
+	}
+	if recordErr != nil {
+		return len(retryList), len(successList), fmt.Errorf("Could not record a failed delete attempt - %v", recordErr)
+	}
+
+	return len(retryList), len(successList), nil
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteRetryPolicyNextAttempt(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := DeleteRetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, MaxAttempts: 6}
+	unlimited := DeleteRetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   DeleteRetryPolicy
+		attempts int
+		// want is the full delay, the jittered delay lies in [want/2, want)
+		want   time.Duration
+		giveUp bool
+	}{
+		{"first failure", policy, 1, time.Minute, false},
+		{"no attempts yet", policy, 0, time.Minute, false},
+		{"doubles", policy, 2, 2 * time.Minute, false},
+		{"doubles again", policy, 4, 8 * time.Minute, false},
+		{"capped at max delay", policy, 5, 10 * time.Minute, false},
+		{"gives up at max attempts", policy, 6, 0, true},
+		{"gives up past max attempts", policy, 9, 0, true},
+		{"unlimited attempts", unlimited, 40, 10 * time.Minute, false},
+		{"shift overflow", unlimited, 1000, 10 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				next := tt.policy.nextAttempt(tt.attempts, now)
+				if tt.giveUp {
+					if next != nil {
+						t.Fatalf("nextAttempt(%d) = %v, want nil", tt.attempts, next)
+					}
+					return
+				}
+				if next == nil {
+					t.Fatalf("nextAttempt(%d) = nil, want a time", tt.attempts)
+				}
+				if delay := next.Sub(now); delay < tt.want/2 || delay >= tt.want {
+					t.Fatalf("nextAttempt(%d) waits %v, want between %v and %v", tt.attempts, delay, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
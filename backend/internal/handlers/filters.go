@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultColorDistance is how far (delta E) a palette colour may be from ?color= when
+// colorDistance is not given. Around 20 keeps the hue while allowing lighter and darker shades.
+const DefaultColorDistance = 20
+
+// parsePhotoFilter reads the feed filters from the query string.
+// Every parameter is optional, malformed values are rejected instead of silently ignored.
+func parsePhotoFilter(c *gin.Context) (database.PhotoFilter, error) {
+	var filter database.PhotoFilter
+	var err error
+
+	for _, value := range c.QueryArray("tag") {
+		for name := range strings.SplitSeq(value, ",") {
+			if strings.TrimSpace(name) != "" {
+				filter.Tags = append(filter.Tags, strings.TrimSpace(name))
+			}
+		}
+	}
+
+	switch strings.ToLower(c.DefaultQuery("tagMode", "any")) {
+	case "any":
+		filter.MatchAllTags = false
+	case "all":
+		filter.MatchAllTags = true
+	default:
+		return filter, fmt.Errorf("tagMode must be either any or all")
+	}
+
+	filter.Camera = strings.TrimSpace(c.Query("camera"))
+	filter.Lens = strings.TrimSpace(c.Query("lens"))
+
+	if filter.MinISO, err = queryInt(c, "isoMin"); err != nil {
+		return filter, err
+	}
+	if filter.MaxISO, err = queryInt(c, "isoMax"); err != nil {
+		return filter, err
+	}
+	if filter.MinAperture, err = queryFloat(c, "apertureMin"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAperture, err = queryFloat(c, "apertureMax"); err != nil {
+		return filter, err
+	}
+	if filter.MinFocalLength, err = queryFloat(c, "focalMin"); err != nil {
+		return filter, err
+	}
+	if filter.MaxFocalLength, err = queryFloat(c, "focalMax"); err != nil {
+		return filter, err
+	}
+	if filter.TakenAfter, err = queryDate(c, "takenAfter", false); err != nil {
+		return filter, err
+	}
+	if filter.TakenBefore, err = queryDate(c, "takenBefore", true); err != nil {
+		return filter, err
+	}
+	if filter.Color, err = queryColor(c); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func queryInt(c *gin.Context, key string) (*int, error) {
+	raw := strings.TrimSpace(c.Query(key))
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a whole number", key)
+	}
+	return &value, nil
+}
+
+func queryFloat(c *gin.Context, key string) (*float64, error) {
+	raw := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c.Query(key))), "f/")
+	raw = strings.TrimSuffix(raw, "mm")
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", key)
+	}
+	return &value, nil
+}
+
+// queryColor reads ?color=ff8800 (with or without #) and the optional ?colorDistance=.
+func queryColor(c *gin.Context) (*database.ColorQuery, error) {
+	raw := strings.TrimSpace(c.Query("color"))
+	if raw == "" {
+		if c.Query("colorDistance") != "" {
+			return nil, fmt.Errorf("colorDistance needs a color")
+		}
+		return nil, nil
+	}
+
+	r, g, b, err := services.ParseHexColor(raw)
+	if err != nil {
+		return nil, fmt.Errorf("color must be a hex colour like ff8800")
+	}
+
+	distance := float64(DefaultColorDistance)
+	custom, err := queryFloat(c, "colorDistance")
+	if err != nil {
+		return nil, err
+	}
+	if custom != nil {
+		if *custom <= 0 || *custom > 100 {
+			return nil, fmt.Errorf("colorDistance must be between 0 and 100")
+		}
+		distance = *custom
+	}
+
+	l, a, bb := services.RGBToLab(r, g, b)
+	return &database.ColorQuery{L: l, A: a, B: bb, MaxDistance: distance}, nil
+}
+
+// queryDate accepts RFC 3339 timestamps or plain dates. A plain date used as an
+// exclusive upper bound (endOfDay) covers the whole day, so takenBefore=2024-05-01 includes May 1st.
+func queryDate(c *gin.Context, key string, endOfDay bool) (*time.Time, error) {
+	raw := strings.TrimSpace(c.Query(key))
+	if raw == "" {
+		return nil, nil
+	}
+
+	if value, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &value, nil
+	}
+
+	value, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date (2006-01-02) or an RFC 3339 timestamp", key)
+	}
+	if endOfDay {
+		value = value.AddDate(0, 0, 1)
+	}
+	return &value, nil
+}
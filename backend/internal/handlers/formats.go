@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formatPreference is the order formats are served in when a client accepts several equally:
+// the smallest files first, JPEG last because every client can display it.
+var formatPreference = []string{services.FormatAVIF, services.FormatWebP, services.FormatJPEG}
+
+// GET /api/photos/:id/image?w=1280&format=jpeg
+// Redirects to the rendition that fits the client best. The format comes from the Accept header
+// unless ?format= is set, w picks the smallest rendition at least that wide (the largest by default).
+// Meant for places that cannot use srcset, e.g. email clients and feed readers.
+func (h *PhotoHandler) GetPhotoImage(c *gin.Context) {
+	width := 0
+	if value := c.Query("w"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "w must be a positive number of pixels"})
+			return
+		}
+		width = parsed
+	}
+
+	format := ""
+	if value := c.Query("format"); value != "" {
+		parsed, err := services.ParseFormat(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		format = parsed
+	}
+
+	photo, err := database.GetPhotoByID(h.DB, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch photo"})
+		return
+	}
+	if photo == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo Not Found"})
+		return
+	}
+
+	available := make(map[string]bool)
+	for _, rendition := range photo.Renditions {
+		available[rendition.Format] = true
+	}
+
+	if format != "" && !available[format] {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo has no " + format + " rendition"})
+		return
+	}
+	if format == "" {
+		format = negotiateFormat(c.GetHeader("Accept"), available)
+	}
+
+	// the response depends on Accept whichever branch answers, caches must not share it across clients
+	c.Header("Vary", "Accept")
+
+	rendition := pickRendition(photo.Renditions, format, width)
+	if rendition == nil {
+		// photos uploaded before renditions existed only have the uploaded image
+		c.Redirect(http.StatusFound, h.Storage.PublicURL(photo.ImageKey))
+		return
+	}
+
+	log.Printf("[FORMAT] Serving %s rendition %dx%d of photo %s", rendition.Format, rendition.Width, rendition.Height, photo.ID)
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Redirect(http.StatusFound, h.Storage.PublicURL(rendition.Key))
+}
+
+// negotiateFormat picks the format of available that the Accept header rates highest.
+// AVIF and WebP are only chosen when the client names them: email clients and old readers often
+// send */* without being able to decode WebP. JPEG is the baseline any wildcard accepts.
+// If the photo has no JPEG, the best available format is returned anyway rather than nothing.
+func negotiateFormat(accept string, available map[string]bool) string {
+	ranges := parseAccept(accept)
+
+	best, bestQ := "", 0.0
+	for _, format := range formatPreference {
+		if !available[format] {
+			continue
+		}
+
+		q, listed := ranges[services.FormatMIMEType(format)]
+		if !listed && format == services.FormatJPEG {
+			q, listed = ranges["image/*"]
+			if !listed {
+				q, listed = ranges["*/*"]
+			}
+			if !listed && accept == "" {
+				q = 1
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	for _, format := range formatPreference {
+		if available[format] {
+			return format
+		}
+	}
+	return ""
+}
+
+// parseAccept maps every media range of an Accept header to its q value,
+// e.g. "image/webp,*/*;q=0.8" -> {"image/webp": 1, "*/*": 0.8}.
+func parseAccept(accept string) map[string]float64 {
+	ranges := make(map[string]float64)
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		if mediaRange == "" {
+			continue
+		}
+
+		q := 1.0
+		for param := range strings.SplitSeq(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges[mediaRange] = q
+	}
+	return ranges
+}
+
+// pickRendition returns the smallest rendition of format that is at least width pixels wide,
+// or the largest one when none is wide enough or width is 0.
+func pickRendition(renditions []models.Rendition, format string, width int) *models.Rendition {
+	var picked *models.Rendition
+	for i := range renditions {
+		rendition := &renditions[i]
+		if rendition.Format != format {
+			continue
+		}
+
+		switch {
+		case picked == nil:
+			picked = rendition
+		case width > 0 && rendition.Width >= width:
+			if picked.Width < width || rendition.Width < picked.Width {
+				picked = rendition
+			}
+		case picked.Width < width || width == 0:
+			if rendition.Width > picked.Width {
+				picked = rendition
+			}
+		}
+	}
+	return picked
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	all := map[string]bool{services.FormatAVIF: true, services.FormatWebP: true, services.FormatJPEG: true}
+	webpAndJPEG := map[string]bool{services.FormatWebP: true, services.FormatJPEG: true}
+	webpOnly := map[string]bool{services.FormatWebP: true}
+
+	tests := []struct {
+		name      string
+		accept    string
+		available map[string]bool
+		want      string
+	}{
+		{"no header", "", webpAndJPEG, services.FormatJPEG},
+		{"wildcard gets jpeg", "*/*", all, services.FormatJPEG},
+		{"image wildcard gets jpeg", "image/*", all, services.FormatJPEG},
+		{"browser", "image/avif,image/webp,image/apng,*/*;q=0.8", all, services.FormatAVIF},
+		{"browser without avif rendition", "image/avif,image/webp,*/*;q=0.8", webpAndJPEG, services.FormatWebP},
+		{"webp preferred by q", "image/avif;q=0.5,image/webp", all, services.FormatWebP},
+		{"jpeg rated higher", "image/webp;q=0.5,image/jpeg", all, services.FormatJPEG},
+		{"webp refused", "image/webp;q=0,*/*", webpAndJPEG, services.FormatJPEG},
+		{"case insensitive", "IMAGE/WEBP", webpAndJPEG, services.FormatWebP},
+		{"nothing acceptable falls back to the best available", "text/html", webpOnly, services.FormatWebP},
+		{"no renditions", "image/webp", map[string]bool{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateFormat(tt.accept, tt.available); got != tt.want {
+				t.Errorf("negotiateFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickRendition(t *testing.T) {
+	renditions := []models.Rendition{
+		{Key: "640.webp", Width: 640, Format: services.FormatWebP},
+		{Key: "320.webp", Width: 320, Format: services.FormatWebP},
+		{Key: "1280.webp", Width: 1280, Format: services.FormatWebP},
+		{Key: "2048.jpg", Width: 2048, Format: services.FormatJPEG},
+		{Key: "320.jpg", Width: 320, Format: services.FormatJPEG},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		width  int
+		want   string
+	}{
+		{"largest by default", services.FormatWebP, 0, "1280.webp"},
+		{"exact width", services.FormatWebP, 640, "640.webp"},
+		{"smallest wide enough", services.FormatWebP, 500, "640.webp"},
+		{"smaller than every rendition", services.FormatWebP, 10, "320.webp"},
+		{"wider than every rendition", services.FormatWebP, 4000, "1280.webp"},
+		{"other format", services.FormatJPEG, 500, "2048.jpg"},
+		{"missing format", services.FormatAVIF, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pickRendition(renditions, tt.format, tt.width)
+			key := ""
+			if got != nil {
+				key = got.Key
+			}
+			if key != tt.want {
+				t.Errorf("pickRendition(%s, %d) = %q, want %q", tt.format, tt.width, key, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPhotoImage(t *testing.T) {
+	h := newTestHandler(t)
+	// a photo from before renditions existed, only the uploaded image is stored
+	id, err := database.CreatePhoto(h.DB, &models.Photo{ImageKey: "web/legacy.jpg", ThumbnailKey: "thumbnails/legacy.jpg", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("create photo: %v", err)
+	}
+	r := gin.New()
+	r.GET("/photos/:id/image", h.GetPhotoImage)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"unknown format", "/photos/" + id + "/image?format=gif", http.StatusBadRequest},
+		{"format without rendition", "/photos/" + id + "/image?format=webp", http.StatusNotFound},
+		{"invalid width", "/photos/" + id + "/image?w=-1", http.StatusBadRequest},
+		{"missing photo", "/photos/missing/image", http.StatusNotFound},
+		{"fallback to the uploaded image", "/photos/" + id + "/image", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusFound {
+				return
+			}
+			if got := rec.Header().Get("Location"); got != "/media/web/legacy.jpg" {
+				t.Errorf("Location = %q, want the uploaded image", got)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want Accept", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultJobRetention is how long a finished upload job can still be polled.
+	DefaultJobRetention = 24 * time.Hour
+	// jobHeartbeat keeps idle event streams open, proxies and tunnels drop silent connections.
+	jobHeartbeat = 15 * time.Second
+)
+
+// DefaultSpoolDir is where asynchronous uploads wait to be processed unless UPLOAD_SPOOL_DIR is set.
+func DefaultSpoolDir() string {
+	return filepath.Join(os.TempDir(), "shutterdev-uploads")
+}
+
+// wantsAsync reports whether the client asked for a 202 and a job instead of waiting for the
+// upload, with ?async=true or a "Prefer: respond-async" header.
+func wantsAsync(c *gin.Context) bool {
+	if c.Query("async") == "true" {
+		return true
+	}
+	for _, preference := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+			c.Header("Preference-Applied", "respond-async")
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueUpload validates an image, spools it to disk and answers 202 with the job that will
+// process it. Uploads that break the UploadPolicy are rejected right away and never become a job.
+func (h *PhotoHandler) enqueueUpload(c *gin.Context, file *multipart.FileHeader, fields uploadFields) {
+	imageData, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open image"})
+		return
+	}
+	defer imageData.Close()
+
+	if _, err := h.validateUpload(imageData, file.Size); err != nil {
+		status, body := h.uploadErrorResponse(file.Filename, err)
+		c.JSON(status, body)
+		return
+	}
+
+	encodedFields, err := json.Marshal(fields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	job := &models.UploadJob{
+		ID:        uuid.New().String(),
+		FileName:  file.Filename,
+		Stage:     models.JobReceived,
+		Fields:    string(encodedFields),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	job.SpoolPath = filepath.Join(h.SpoolDir, job.ID)
+
+	if err := spoolFile(job.SpoolPath, imageData); err != nil {
+		log.Printf("[%v - FAILED] Could not spool upload - %v", file.Filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not store the upload"})
+		return
+	}
+	if err := h.startJob(c.Request.Context(), job); err != nil {
+		log.Printf("[%v - FAILED] %v", file.Filename, err)
+		os.Remove(job.SpoolPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the upload job"})
+		return
+	}
+
+	statusURL := "/api/admin/jobs/" + job.ID
+	c.Header("Location", statusURL)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   fmt.Sprintf("Received - %v", file.Filename),
+		"jobId":     job.ID,
+		"stage":     job.Stage,
+		"statusUrl": statusURL,
+		"eventsUrl": statusURL + "/events",
+	})
+}
+
+// startJob stores a received job whose original is spooled and queues it for a worker.
+func (h *PhotoHandler) startJob(ctx context.Context, job *models.UploadJob) error {
+	if err := database.CreateUploadJob(h.DB, ctx, job); err != nil {
+		return err
+	}
+	h.queueJob(job)
+	return nil
+}
+
+// queueJob hands a stored job to the workers and tells its event streams.
+func (h *PhotoHandler) queueJob(job *models.UploadJob) {
+	h.jobs.push(job.ID)
+	h.jobEvents.publish(*job)
+	log.Printf("[JOBS] %v received as job %s", job.FileName, job.ID)
+}
+
+func spoolFile(path string, data io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	spooled, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(spooled, data); err != nil {
+		spooled.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := spooled.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// GET /api/admin/jobs/:id
+func (h *PhotoHandler) GetUploadJob(c *gin.Context) {
+	job, err := database.GetUploadJob(h.DB, c.Request.Context(), c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job Not Found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[JOBS:ERROR] Could not fetch job %s - %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GET /api/admin/jobs/:id/events
+// Server-Sent Events with the job as data, named after its stage. The current stage is sent
+// first, the stream ends after the indexed or failed event.
+func (h *PhotoHandler) StreamUploadJob(c *gin.Context) {
+	id := c.Param("id")
+	events, unsubscribe := h.jobEvents.subscribe(id)
+	defer unsubscribe()
+
+	// read after subscribing, so no stage can slip in between
+	job, err := database.GetUploadJob(h.DB, c.Request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job Not Found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[JOBS:ERROR] Could not fetch job %s - %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch job"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(string(job.Stage), job)
+	c.Writer.Flush()
+	if job.Stage.Done() {
+		return
+	}
+
+	heartbeat := time.NewTicker(jobHeartbeat)
+	defer heartbeat.Stop()
+
+	lastStage := job.Stage
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		case update := <-events:
+			// the stage read above may also arrive as an event
+			if update.Stage == lastStage {
+				continue
+			}
+			lastStage = update.Stage
+			c.SSEvent(string(update.Stage), update)
+			c.Writer.Flush()
+			if update.Stage.Done() {
+				return
+			}
+		}
+	}
+}
+
+// RunUploadJobs resumes the jobs a restart interrupted, processes received jobs with one worker per
+// processing pool slot, drops finished jobs after JobRetention and expired resumable uploads,
+// until ctx is cancelled.
+func (h *PhotoHandler) RunUploadJobs(ctx context.Context) {
+	unfinished, err := database.GetUnfinishedUploadJobs(h.DB, ctx)
+	if err != nil {
+		log.Printf("[JOBS:ERROR] Could not fetch unfinished jobs - %v", err)
+	}
+	for _, job := range unfinished {
+		h.jobs.push(job.ID)
+	}
+	if len(unfinished) > 0 {
+		log.Printf("[JOBS] Resuming %d upload jobs", len(unfinished))
+	}
+
+	workers := h.Pool.Concurrency()
+	for range workers {
+		go func() {
+			for {
+				id, ok := h.jobs.pop(ctx)
+				if !ok {
+					return
+				}
+				h.runUploadJob(ctx, id)
+				h.jobs.done(id)
+			}
+		}()
+	}
+	log.Printf("[JOBS] %d workers, spooling uploads to %s", workers, h.SpoolDir)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		purgeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if h.JobRetention > 0 {
+			if removed, err := database.DeleteFinishedUploadJobs(h.DB, purgeCtx, time.Now().Add(-h.JobRetention)); err != nil {
+				log.Printf("[JOBS:ERROR] Could not remove finished jobs - %v", err)
+			} else if removed > 0 {
+				log.Printf("[JOBS] Removed %d finished jobs", removed)
+			}
+		}
+		h.expireTusUploads(purgeCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runUploadJob processes a received job from its spooled original. A saturated pool is waited
+// out instead of failing the job, the client is not waiting on the response.
+func (h *PhotoHandler) runUploadJob(ctx context.Context, id string) {
+	job, err := database.GetUploadJob(h.DB, ctx, id)
+	if err != nil {
+		log.Printf("[JOBS:ERROR] Could not fetch job %s - %v", id, err)
+		return
+	}
+	if job.Stage.Done() {
+		return
+	}
+
+	var fields uploadFields
+	if job.Fields != "" {
+		if err := json.Unmarshal([]byte(job.Fields), &fields); err != nil {
+			h.failJob(job, fmt.Errorf("invalid fields for job %s: %v", job.ID, err))
+			return
+		}
+	}
+
+	info, err := os.Stat(job.SpoolPath)
+	if err != nil {
+		h.failJob(job, fmt.Errorf("the uploaded file was lost: %v", err))
+		return
+	}
+	source := uploadSource{
+		Filename: job.FileName,
+		Size:     info.Size(),
+		Open: func() (multipart.File, error) {
+			return os.Open(job.SpoolPath)
+		},
+	}
+
+	result, err := h.processSingleImage(ctx, source, fields, job)
+	for errors.Is(err, services.ErrPoolSaturated) && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(h.Pool.RetryAfter()):
+			result, err = h.processSingleImage(ctx, source, fields, job)
+		}
+	}
+
+	// the job is marked indexed together with the photo, even if ctx ended right after
+	if err == nil {
+		log.Printf("[JOBS] Job %s indexed as photo %s, took - [%v]", job.ID, result.ID, time.Since(job.CreatedAt))
+		h.removeSpooled(job)
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down, the job starts over from the spooled original on the next start
+		return
+	}
+	h.failJob(job, err)
+}
+
+// failJob records why a job failed, with the same error code a synchronous upload answers with.
+func (h *PhotoHandler) failJob(job *models.UploadJob, err error) {
+	_, body := h.uploadErrorResponse(job.FileName, err)
+	job.Error, _ = body["error"].(string)
+	job.Code, _ = body["code"].(string)
+	var duplicateErr *DuplicateError
+	if errors.As(err, &duplicateErr) {
+		job.Duplicates = duplicateErr.Matches
+	}
+	h.setJobStage(job, models.JobFailed)
+	h.removeSpooled(job)
+}
+
+// setJobStage stores the new stage and tells the event streams of the job.
+func (h *PhotoHandler) setJobStage(job *models.UploadJob, stage models.JobStage) {
+	job.Stage = stage
+	job.UpdatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := database.UpdateUploadJob(h.DB, ctx, job); err != nil {
+		log.Printf("[JOBS:ERROR] %v", err)
+	}
+	h.jobEvents.publish(*job)
+}
+
+func (h *PhotoHandler) removeSpooled(job *models.UploadJob) {
+	if err := os.Remove(job.SpoolPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[JOBS:ERROR] Could not remove spooled upload %s - %v", job.SpoolPath, err)
+	}
+}
+
+// jobQueue holds the IDs of jobs waiting for a worker, in arrival order. It is not bounded, the
+// originals wait on disk rather than in memory.
+type jobQueue struct {
+	mu   sync.Mutex
+	ids  []string
+	wake chan struct{}
+	// pending are the queued and running IDs, a job resumed at startup is never run twice
+	pending map[string]struct{}
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{wake: make(chan struct{}, 1), pending: make(map[string]struct{})}
+}
+
+func (q *jobQueue) push(id string) {
+	q.mu.Lock()
+	if _, ok := q.pending[id]; ok {
+		q.mu.Unlock()
+		return
+	}
+	q.pending[id] = struct{}{}
+	q.ids = append(q.ids, id)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop waits for the next ID until ctx ends.
+func (q *jobQueue) pop(ctx context.Context) (string, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.ids) > 0 {
+			id := q.ids[0]
+			q.ids = q.ids[1:]
+			more := len(q.ids) > 0
+			q.mu.Unlock()
+			if more {
+				// pass the wake-up on, several jobs may have been pushed for one signal
+				q.signal()
+			}
+			return id, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-q.wake:
+		}
+	}
+}
+
+// done forgets a job once a worker has finished with it.
+func (q *jobQueue) done(id string) {
+	q.mu.Lock()
+	delete(q.pending, id)
+	q.mu.Unlock()
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// jobHub fans stage changes out to the event streams of a job.
+type jobHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.UploadJob]struct{}
+}
+
+// subscribe returns the updates of job id until unsubscribe is called.
+func (hub *jobHub) subscribe(id string) (<-chan models.UploadJob, func()) {
+	// a job has five stages at most, the buffer never fills up
+	events := make(chan models.UploadJob, 8)
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.subscribers == nil {
+		hub.subscribers = make(map[string]map[chan models.UploadJob]struct{})
+	}
+	if hub.subscribers[id] == nil {
+		hub.subscribers[id] = make(map[chan models.UploadJob]struct{})
+	}
+	hub.subscribers[id][events] = struct{}{}
+
+	return events, func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		delete(hub.subscribers[id], events)
+		if len(hub.subscribers[id]) == 0 {
+			delete(hub.subscribers, id)
+		}
+	}
+}
+
+func (hub *jobHub) publish(job models.UploadJob) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for events := range hub.subscribers[job.ID] {
+		select {
+		case events <- job:
+		default:
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadJobIsIndexedWithItsPhoto(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+
+	body, contentType := multipartUpload(t, "async.jpg", testJPEG(t, 320, 240), nil)
+	req := httptest.NewRequest(http.MethodPost, "/photos?async=true", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("upload status = %d, body %s", rec.Code, rec.Body)
+	}
+	var accepted struct {
+		JobID string `json:"jobId"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil || accepted.JobID == "" {
+		t.Fatalf("response %s has no jobId (%v)", rec.Body, err)
+	}
+
+	events, unsubscribe := h.jobEvents.subscribe(accepted.JobID)
+	defer unsubscribe()
+
+	ctx := context.Background()
+	h.runUploadJob(ctx, accepted.JobID)
+
+	job, err := database.GetUploadJob(h.DB, ctx, accepted.JobID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if job.Stage != models.JobIndexed || job.PhotoID == "" {
+		t.Fatalf("job is %s with photo %q, want indexed with a photo", job.Stage, job.PhotoID)
+	}
+	if photo, err := database.GetPhotoByID(h.DB, job.PhotoID); err != nil || photo == nil {
+		t.Fatalf("photo %s of the job not found (err %v)", job.PhotoID, err)
+	}
+	if _, err := os.Stat(job.SpoolPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spooled original %s was not removed (err %v)", job.SpoolPath, err)
+	}
+
+	var stages []models.JobStage
+	for len(events) > 0 {
+		stages = append(stages, (<-events).Stage)
+	}
+	want := []models.JobStage{models.JobProcessed, models.JobStored, models.JobIndexed}
+	if len(stages) != len(want) {
+		t.Fatalf("events = %v, want %v", stages, want)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Fatalf("events = %v, want %v", stages, want)
+		}
+	}
+
+	// a job that is already indexed is never processed again
+	h.runUploadJob(ctx, accepted.JobID)
+	var photos int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM photos`).Scan(&photos); err != nil {
+		t.Fatal(err)
+	}
+	if photos != 1 {
+		t.Errorf("%d photos stored, want 1", photos)
+	}
+}
+
+// readJobEvents reads an event stream until the server closes it and returns the event names.
+func readJobEvents(t *testing.T, client *http.Client, url string, afterFirst func()) []string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("event stream status = %d", resp.StatusCode)
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, ok := strings.CutPrefix(scanner.Text(), "event:")
+		if !ok {
+			continue
+		}
+		names = append(names, name)
+		if len(names) == 1 && afterFirst != nil {
+			afterFirst()
+		}
+	}
+	// the client timeout ends a stream the server never closes
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("event stream did not close after %v: %v", names, err)
+	}
+	return names
+}
+
+func TestStreamUploadJob(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+	r.GET("/jobs/:id/events", h.StreamUploadJob)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	body, contentType := multipartUpload(t, "async.jpg", testJPEG(t, 320, 240), nil)
+	resp, err := client.Post(server.URL+"/photos?async=true", contentType, body)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	var accepted struct {
+		JobID string `json:"jobId"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || err != nil || accepted.JobID == "" {
+		t.Fatalf("upload status = %d, job %q (%v)", resp.StatusCode, accepted.JobID, err)
+	}
+	eventsURL := server.URL + "/jobs/" + accepted.JobID + "/events"
+
+	// the job only runs once the stream has sent its first event, so the stream is subscribed
+	done := make(chan struct{})
+	names := readJobEvents(t, client, eventsURL, func() {
+		go func() {
+			defer close(done)
+			h.runUploadJob(context.Background(), accepted.JobID)
+		}()
+	})
+	<-done
+	want := []string{"received", "processed", "stored", "indexed"}
+	if !slices.Equal(names, want) {
+		t.Errorf("events = %v, want %v", names, want)
+	}
+
+	// a finished job sends its final stage once and closes the stream
+	if names := readJobEvents(t, client, eventsURL, nil); !slices.Equal(names, []string{"indexed"}) {
+		t.Errorf("events of a finished job = %v, want [indexed]", names)
+	}
+
+	resp, err = client.Get(server.URL + "/jobs/no-such-job/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job status = %d, want 404", resp.StatusCode)
+	}
+}
@@ -1,32 +1,75 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log"
+	"math"
 	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"shutterdev/backend/internal/database"
 	"shutterdev/backend/internal/models"
 	"shutterdev/backend/internal/services"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/sync/errgroup"
 )
 
 type PhotoHandler struct {
-	DB        *sql.DB
-	R2Service *services.R2Service
+	DB      *sql.DB
+	Storage services.Storage
+	// TrashRetention is how long photos stay in the trash before they are purged, 0 keeps them forever
+	TrashRetention time.Duration
+	// DeleteRetry controls how the background worker retries failed storage deletes
+	DeleteRetry DeleteRetryPolicy
+	// Renditions are the resized copies rendered for every upload
+	Renditions []services.RenditionSpec
+	// Duplicates decides whether uploads that look like a stored photo are accepted
+	Duplicates DuplicatePolicy
+	// DuplicateThreshold is the largest perceptual hash distance that counts as a duplicate
+	DuplicateThreshold int
+	// Pool bounds how many uploads are decoded and resized at the same time
+	Pool *services.ProcessingPool
+	// UploadPolicy is checked before an upload is decoded
+	UploadPolicy services.UploadPolicy
+	// MaxBatchFiles is how many images POST /admin/photos/batch accepts at once
+	MaxBatchFiles int
+	// SpoolDir holds the originals of asynchronous uploads until they are processed
+	SpoolDir string
+	// JobRetention is how long finished upload jobs can be polled, 0 keeps them forever
+	JobRetention time.Duration
+	// TusExpiry is how long an unfinished resumable upload is kept after its last chunk
+	TusExpiry time.Duration
+
+	retryMu   sync.Mutex
+	jobs      *jobQueue
+	jobEvents jobHub
+	tusMu     sync.Mutex
+	tusBusy   map[string]struct{}
+	inFlight  inFlightHashes
+}
+
+type UpdatePhotoRequest struct {
+	Title   *string   `json:"title"`
+	Caption *string   `json:"caption"`
+	AltText *string   `json:"altText"`
+	Tags    *[]string `json:"tags"`
+	// Exif is kept raw per field so an explicit null can be told apart from a field that was left out
+	Exif map[string]json.RawMessage `json:"exif"`
 }
 
 type DeleteRequest struct {
@@ -34,17 +77,40 @@ type DeleteRequest struct {
 	Password       string   `json:"password"`
 }
 
-func NewPhotoHandler(db *sql.DB, r2 *services.R2Service) *PhotoHandler {
+func NewPhotoHandler(db *sql.DB, storage services.Storage) *PhotoHandler {
 	return &PhotoHandler{
-		DB:        db,
-		R2Service: r2,
+		DB:                 db,
+		Storage:            storage,
+		TrashRetention:     30 * 24 * time.Hour,
+		DeleteRetry:        DefaultDeleteRetryPolicy,
+		Renditions:         services.DefaultRenditions,
+		Duplicates:         DuplicatesWarn,
+		DuplicateThreshold: DefaultDuplicateThreshold,
+		Pool:               services.NewProcessingPool(services.DefaultPoolConfig()),
+		UploadPolicy:       services.DefaultUploadPolicy,
+		MaxBatchFiles:      DefaultMaxBatchFiles,
+		SpoolDir:           DefaultSpoolDir(),
+		JobRetention:       DefaultJobRetention,
+		TusExpiry:          DefaultTusExpiry,
+		jobs:               newJobQueue(),
 	}
 }
 
 // GET /api/photos?cursor=x (x is base64 string of json)
+// optional filters: tag (repeatable or comma separated), tagMode=any|all, camera, lens,
+// isoMin, isoMax, apertureMin, apertureMax, focalMin, focalMax, takenAfter, takenBefore,
+// color (hex), colorDistance
 func (h *PhotoHandler) GetAllPhotos(c *gin.Context) {
 	const LIMIT = 10
 	var err error
+
+	filter, err := parsePhotoFilter(c)
+	if err != nil {
+		log.Printf("[FILTER] Invalid filter received - %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	cursor := c.Query("cursor")
 	decodedCursor, err := base64.StdEncoding.DecodeString(cursor)
 	if err != nil {
@@ -55,12 +121,13 @@ func (h *PhotoHandler) GetAllPhotos(c *gin.Context) {
 
 	if string(decodedCursor) == "" {
 		log.Println("[DECODE CURSOR] Decoded cursor is empty - requesting first page")
-		photos, err := database.GetAllPhotos(h.DB, time.Time{}, "", LIMIT)
+		photos, err := database.GetAllPhotos(h.DB, time.Time{}, "", LIMIT, filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch photos"})
 			return
 		}
 
+		h.withThumbnailURLs(photos.Photos)
 		c.JSON(http.StatusOK, photos)
 		return
 	}
@@ -81,13 +148,14 @@ func (h *PhotoHandler) GetAllPhotos(c *gin.Context) {
 
 	log.Println("[CURSOR: created_at] " + (cursorObtained.CreatedAt).String())
 	log.Println("[CURSOR: ID] " + cursorObtained.ID)
-	photos, err := database.GetAllPhotos(h.DB, cursorObtained.CreatedAt, cursorObtained.ID, LIMIT)
+	photos, err := database.GetAllPhotos(h.DB, cursorObtained.CreatedAt, cursorObtained.ID, LIMIT, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch photos"})
 		fmt.Println(err)
 		return
 	}
 
+	h.withThumbnailURLs(photos.Photos)
 	c.JSON(http.StatusOK, photos)
 }
 
@@ -105,15 +173,27 @@ func (h *PhotoHandler) GetPhotoByID(c *gin.Context) {
 		return
 	}
 
+	h.withPhotoURLs(photo)
 	c.JSON(http.StatusOK, photo)
 }
 
 // POST /api/admin/photos
+// With ?async=true or "Prefer: respond-async" the upload is answered with 202 and a job once it
+// passed validation, see enqueueUpload.
 func (h *PhotoHandler) UploadPhoto(c *gin.Context) {
 
 	t0 := time.Now()
+	if h.UploadPolicy.MaxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.UploadPolicy.MaxBytes+multipartOverhead)
+	}
 	form, err := c.MultipartForm()
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("[FAILED] Upload body exceeds %d bytes", maxBytesErr.Limit)
+			respondUploadError(c, h.UploadPolicy.FileTooLarge())
+			return
+		}
 		log.Printf("[ERROR] Could now parse multipart form - %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse multipart form"})
 		return
@@ -129,20 +209,105 @@ func (h *PhotoHandler) UploadPhoto(c *gin.Context) {
 
 	if len(files) > 1 {
 		log.Println("[FAILED] Only one image allowed per request")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "only one image allowed per request"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only one image allowed per request, use /api/admin/photos/batch for more"})
 		return
 	}
 
 	file := files[0]
 
-	if err := h.processSingleImage(c, file); err != nil {
-		log.Printf("[%v - FAILED] Could not process image due to an error - %v", file.Filename, err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if wantsAsync(c) {
+		h.enqueueUpload(c, file, readUploadFields(form, ""))
+		return
+	}
+
+	result, err := h.processSingleImage(c.Request.Context(), multipartSource(file), readUploadFields(form, ""), nil)
+	if err != nil {
+		status, body := h.uploadErrorResponse(file.Filename, err)
+		if retryAfter, ok := body["retryAfter"]; ok {
+			c.Header("Retry-After", strconv.Itoa(retryAfter.(int)))
+		}
+		c.JSON(status, body)
 		return
 	}
 
 	log.Printf("[%v - SUCCESS] Took - [%v]", file.Filename, time.Since(t0))
-	c.JSON(http.StatusCreated, gin.H{"message": fmt.Sprintf("Successfully uploaded - %v", file.Filename)})
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    fmt.Sprintf("Successfully uploaded - %v", file.Filename),
+		"id":         result.ID,
+		"duplicates": result.Duplicates,
+	})
+}
+
+// PATCH /api/admin/photos/:id
+func (h *PhotoHandler) UpdatePhoto(c *gin.Context) {
+	idStr := c.Param("id")
+
+	var updateRequest UpdatePhotoRequest
+	if bindError := c.ShouldBindJSON(&updateRequest); bindError != nil {
+		log.Printf("[UPDATE:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	photo, err := database.GetPhotoByID(h.DB, idStr)
+	if err != nil {
+		log.Printf("[UPDATE:ERROR] Could not fetch photo (%s) - %v", idStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch photo"})
+		return
+	}
+	if photo == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo Not Found"})
+		return
+	}
+
+	update := database.PhotoUpdate{
+		Title:   trimmedPtr(updateRequest.Title),
+		Caption: trimmedPtr(updateRequest.Caption),
+		AltText: trimmedPtr(updateRequest.AltText),
+	}
+
+	if updateRequest.Tags != nil {
+		tags := make([]models.Tag, 0, len(*updateRequest.Tags))
+		for _, name := range *updateRequest.Tags {
+			tags = append(tags, models.Tag{Name: name})
+		}
+		update.Tags = &tags
+	}
+
+	// EXIF edits are overrides on top of what is stored, like the exif field on upload
+	if updateRequest.Exif != nil {
+		mergedExif, err := patchExif(photo.Exif, updateRequest.Exif)
+		if err != nil {
+			log.Printf("[UPDATE:ERROR] Invalid exif for photo (%s) - %v", idStr, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exif"})
+			return
+		}
+		update.Exif = &mergedExif
+	}
+
+	if err := database.UpdatePhoto(h.DB, ctx, idStr, update); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Photo Not Found"})
+			return
+		}
+		log.Printf("[UPDATE:ERROR] Could not update photo (%s) - %v", idStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update photo"})
+		return
+	}
+
+	updatedPhoto, err := database.GetPhotoByID(h.DB, idStr)
+	if err != nil || updatedPhoto == nil {
+		log.Printf("[UPDATE:ERROR] Could not fetch updated photo (%s) - %v", idStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch photo"})
+		return
+	}
+
+	log.Printf("[UPDATE] Successfully updated photo (%s)", idStr)
+	h.withPhotoURLs(updatedPhoto)
+	c.JSON(http.StatusOK, updatedPhoto)
 }
 
 // DELETE /api/admin/photos
@@ -171,14 +336,16 @@ func (h *PhotoHandler) DeletePhotos(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 
-	resp, err := h.deleteByIDs(ctx, deleteRequest.DeleteIDsArray)
+	// photos only go to the trash here, blobs are removed when the trash is emptied or purged
+	trashed, err := database.TrashPhotos(h.DB, ctx, deleteRequest.DeleteIDsArray)
 	if err != nil {
-		log.Printf("[DELETE:ERROR] %v", err)
-		c.JSON(http.StatusInternalServerError, resp)
+		log.Printf("[DELETE:ERROR] Could not move photos to the trash - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not move photos to the trash"})
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	log.Printf("[DELETE] Moved %d photos to the trash", trashed)
+	c.JSON(http.StatusOK, gin.H{"trashed": trashed})
 }
 
 // DELETE /api/admin/photos/all
@@ -200,249 +367,468 @@ func (h *PhotoHandler) DeleteAllPhotos(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
-	toDeleteIds, err := database.GetAllPhotoIDs(h.DB, ctx)
-	if err != nil {
-		log.Printf("[DELETE:ERROR] Could not get All the IDs of photos to Delete - %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get All the IDs of photos to Delete"})
-		return
-	}
 
-	resp, err := h.deleteByIDs(ctx, toDeleteIds)
+	trashed, err := database.TrashAllPhotos(h.DB, ctx)
 	if err != nil {
-		log.Printf("[DELETE:ERROR] %v", err)
-		c.JSON(http.StatusInternalServerError, resp)
+		log.Printf("[DELETE:ERROR] Could not move all photos to the trash - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not move all photos to the trash"})
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	log.Printf("[DELETE] Moved all %d photos to the trash", trashed)
+	c.JSON(http.StatusOK, gin.H{"trashed": trashed})
 }
 
 // DELETE /api/admin/photos/failed
+// Retries every queued delete right away, including the ones the retry worker gave up on.
 func (h *PhotoHandler) NukeFailedBlobs(c *gin.Context) {
-
-	type FailedRow struct {
-		id       string
-		webURL   string
-		thumbURL string
-	}
-
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
-	var retryList []FailedRow
-	var successList []string
-	var successCounter int
 
-	getFailedList := `SELECT id, web_url, thumbnail_url FROM failed_storage_deletes`
-	tx, err := h.DB.BeginTx(ctx, nil)
+	retried, successCounter, err := h.retryFailedDeletes(ctx, func(ctx context.Context) ([]models.FailedDelete, error) {
+		return database.GetFailedDeletes(h.DB, ctx)
+	})
 	if err != nil {
-		log.Printf("[NUKE ORPHANS] An error occured when trying to start a new transaction - %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured when trying to start a new transaction"})
+		log.Printf("[NUKE ORPHANS] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured in trying to update failed_storage_deletes"})
 		return
 	}
-	defer tx.Rollback()
 
-	rows, err := tx.QueryContext(ctx, getFailedList)
-	if err != nil {
-		log.Printf("[NUKE ORPHANS] An error occured querying to the database - %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured querying to the database"})
-		return
+	resp := gin.H{
+		"success": successCounter,
+		"failed":  (retried - successCounter),
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var fr FailedRow
-		if err := rows.Scan(&fr.id, &fr.webURL, &fr.thumbURL); err != nil {
-			log.Printf("[NUKE ORPHANS] An error occured in trying to scan the rows from the QueryResult - %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured in trying to scan the rows from the QueryResult"})
-			return
-		}
+	c.JSON(http.StatusOK, resp)
+}
 
-		retryList = append(retryList, fr)
+// <== Helper Functions ==>
+func trimmedPtr(value *string) *string {
+	if value == nil {
+		return nil
 	}
+	trimmed := strings.TrimSpace(*value)
+	return &trimmed
+}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("[NUKE ORPHANS] An error occured in reading Rows - %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured in reading Rows"})
-		return
+// patchExif applies the exif block of a PATCH to the stored values. Fields with a value override
+// the stored one, fields sent as null are cleared and fields that are left out stay as they are.
+func patchExif(base models.Exif, patch map[string]json.RawMessage) (models.Exif, error) {
+	values, err := json.Marshal(patch)
+	if err != nil {
+		return base, err
+	}
+	var override models.Exif
+	if err := json.Unmarshal(values, &override); err != nil {
+		return base, err
 	}
 
-	if len(retryList) == 0 {
-		if err := tx.Commit(); err != nil {
-			log.Printf("[NUKE ORPHANS] Commit failed: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "commit failed"})
-			return
+	merged := services.MergeExif(base, override)
+	for field, value := range patch {
+		if string(bytes.TrimSpace(value)) == "null" {
+			services.ClearExifField(&merged, field)
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"success": 0,
-			"failed":  len(retryList),
-		})
-		return
 	}
+	return merged, nil
+}
 
-	for _, photo := range retryList {
-		if err := h.deleteBlobs(photo.webURL, photo.thumbURL, c); err != nil {
-			log.Printf("[NUKE ORPHANS] An error trying to delete the Photo (%s) - %v", photo.id, err)
-			continue
+// uploadResult is what a successful upload reports back.
+type uploadResult struct {
+	ID string
+	// Duplicates are the stored photos the upload looks like, under DuplicatesWarn
+	Duplicates []models.SimilarPhoto
+}
+
+// uploadFields are the form fields sent along with an image.
+type uploadFields struct {
+	Title          string `json:"title"`
+	Caption        string `json:"caption"`
+	AltText        string `json:"altText"`
+	Tags           string `json:"tags"`
+	Exif           string `json:"exif"`
+	AllowDuplicate bool   `json:"allowDuplicate"`
+}
+
+// readUploadFields reads the fields of one image, each named field+suffix, e.g. "title[2]".
+// A field missing under the suffix falls back to the plain name, so a batch can share its tags.
+func readUploadFields(form *multipart.Form, suffix string) uploadFields {
+	value := func(name string) string {
+		if values := form.Value[name+suffix]; len(values) > 0 {
+			return values[0]
+		}
+		if values := form.Value[name]; len(values) > 0 {
+			return values[0]
 		}
-		successList = append(successList, photo.id)
-		successCounter++
+		return ""
 	}
+	return uploadFields{
+		Title:          value("title"),
+		Caption:        value("caption"),
+		AltText:        value("altText"),
+		Tags:           value("tags"),
+		Exif:           value("exif"),
+		AllowDuplicate: value("allowDuplicate") == "true",
+	}
+}
 
-	if len(successList) == 0 {
-		if err := tx.Commit(); err != nil {
-			log.Printf("[NUKE ORPHANS] Commit failed: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "commit failed"})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"success": 0,
-			"failed":  len(retryList),
-		})
+// multipartOverhead is the room left in the request body for the form fields and part headers
+// next to the image itself.
+const multipartOverhead = 1 << 20
+
+// respondUploadError answers with the status and code of a *services.ValidationError.
+func respondUploadError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	if !errors.As(err, &validationErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(validationErr.Status, gin.H{"error": validationErr.Message, "code": validationErr.Code})
+}
 
-	placeholders := make([]string, len(successList))
-	args := make([]any, len(successList))
+// Error codes of uploads that failed after validation, next to the services.Code* ones.
+const (
+	codeInvalidExif      = "invalid_exif"
+	codeDuplicate        = "duplicate"
+	codeProcessingBusy   = "processing_busy"
+	codeProcessingFailed = "processing_failed"
+)
 
-	for i, id := range successList {
-		placeholders[i] = "?"
-		args[i] = id
+// uploadErrorResponse maps an error of processSingleImage to a status and a body with an error
+// code. A saturated pool also reports retryAfter in whole seconds.
+func (h *PhotoHandler) uploadErrorResponse(fileName string, err error) (int, gin.H) {
+	if errors.Is(err, services.ErrPoolSaturated) {
+		retryAfter := h.Pool.RetryAfter()
+		log.Printf("[%v - BUSY] Processing pool saturated, retry in %v", fileName, retryAfter)
+		return http.StatusServiceUnavailable, gin.H{
+			"error":      err.Error(),
+			"code":       codeProcessingBusy,
+			"retryAfter": int(math.Ceil(retryAfter.Seconds())),
+		}
+	}
+	var duplicateErr *DuplicateError
+	if errors.As(err, &duplicateErr) {
+		log.Printf("[%v - REJECTED] %v", fileName, err)
+		return http.StatusConflict, gin.H{"error": err.Error(), "code": codeDuplicate, "duplicates": duplicateErr.Matches}
 	}
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Printf("[%v - REJECTED] %s: %v", fileName, validationErr.Code, err)
+		return validationErr.Status, gin.H{"error": validationErr.Message, "code": validationErr.Code}
+	}
+	log.Printf("[%v - FAILED] Could not process image due to an error - %v", fileName, err.Error())
+	return http.StatusInternalServerError, gin.H{"error": err.Error(), "code": codeProcessingFailed}
+}
+
+// uploadSource is an image to process: a part of the multipart form, or an original spooled to
+// disk by an asynchronous upload.
+type uploadSource struct {
+	Filename string
+	Size     int64
+	Open     func() (multipart.File, error)
+}
 
-	deleteFromFailedStore := fmt.Sprintf(`DELETE FROM failed_storage_deletes WHERE id IN (%s)`, strings.Join(placeholders, ","))
+func multipartSource(file *multipart.FileHeader) uploadSource {
+	return uploadSource{Filename: file.Filename, Size: file.Size, Open: file.Open}
+}
 
-	_, deleteErr := tx.ExecContext(ctx, deleteFromFailedStore, args...)
-	if deleteErr != nil {
-		log.Printf("[NUKE ORPHANS] An error occured in trying to delete the succeeded rows from failed_storage_deletes - %v", deleteErr)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured in trying to delete the succeeded rows from failed_storage_deletes"})
-		return
+// validateUpload applies the UploadPolicy to an opened image and returns its header. Only the
+// header is read, imageData is rewound to the start afterwards.
+func (h *PhotoHandler) validateUpload(imageData multipart.File, size int64) (image.Config, error) {
+	if err := h.UploadPolicy.CheckSize(size); err != nil {
+		return image.Config{}, err
 	}
-	if err := tx.Commit(); err != nil {
-		log.Printf("[NUKE ORPHANS] Commit failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "commit failed"})
-		return
+
+	buffer := make([]byte, 512)
+	n, err := imageData.Read(buffer)
+	if n == 0 {
+		return image.Config{}, services.UnreadableImage(fmt.Errorf("file is empty"))
+	}
+	if err != nil && err != io.EOF {
+		return image.Config{}, fmt.Errorf("failed to read image")
 	}
 
-	resp := gin.H{
-		"success": successCounter,
-		"failed":  (len(retryList) - successCounter),
+	// the type is sniffed from the content, the Content-Type sent by the client is not trusted
+	if err := h.UploadPolicy.CheckType(http.DetectContentType(buffer[:n])); err != nil {
+		return image.Config{}, err
 	}
 
-	c.JSON(http.StatusOK, resp)
-}
+	if _, err := imageData.Seek(0, io.SeekStart); err != nil {
+		return image.Config{}, fmt.Errorf("failed to reset file pointer")
+	}
 
-// <== Helper Functions ==>
-func getKeyFromURL(fileURL string) (string, error) {
-	parsedURL, err := url.Parse(fileURL)
+	// only the header is read here, the pixels are decoded once the pool has room for them
+	imageConfig, _, err := image.DecodeConfig(imageData)
 	if err != nil {
-		return "", err
+		return image.Config{}, services.UnreadableImage(err)
 	}
-
-	key := path.Clean(parsedURL.Path)
-	key = strings.TrimPrefix(key, "/")
-
-	return key, nil
+	if err := h.UploadPolicy.CheckDimensions(imageConfig.Width, imageConfig.Height); err != nil {
+		return image.Config{}, err
+	}
+	if _, err := imageData.Seek(0, io.SeekStart); err != nil {
+		return image.Config{}, fmt.Errorf("failed to reset file pointer")
+	}
+	return imageConfig, nil
 }
 
-func (h *PhotoHandler) processSingleImage(c *gin.Context, file *multipart.FileHeader) error {
-
-	const MaxUploadSize = 20 << 20
+// processSingleImage validates, processes, stores and indexes one image. job, if not nil, is the
+// upload job of the image: it moves through the stages and is marked indexed together with the photo.
+func (h *PhotoHandler) processSingleImage(ctx context.Context, file uploadSource, fields uploadFields, job *models.UploadJob) (*uploadResult, error) {
+	progress := func(stage models.JobStage) {
+		if job != nil {
+			h.setJobStage(job, stage)
+		}
+	}
 
-	// Parse exif from multipart form data
+	// Parse the optional exif overrides from multipart form data
 	var ReceivedExif models.Exif
-	exifStr := c.PostForm("exif")
-	if exifStr != "" {
-		json.Unmarshal([]byte(exifStr), &ReceivedExif)
+	if fields.Exif != "" {
+		if err := json.Unmarshal([]byte(fields.Exif), &ReceivedExif); err != nil {
+			log.Printf("[%v]: Could not parse EXIF JSON sent by the client - %v", file.Filename, err)
+			return nil, &services.ValidationError{Code: codeInvalidExif, Status: http.StatusBadRequest, Message: "invalid exif field"}
+		}
 	}
 	log.Printf("[%v]: Received Following EXIF - %v", file.Filename, ReceivedExif)
 
 	imageData, err := file.Open()
 	if err != nil {
-		return fmt.Errorf("failed to open image")
+		return nil, fmt.Errorf("failed to open image")
 	}
 	defer imageData.Close()
 
-	buffer := make([]byte, 512)
-	n, err := imageData.Read(buffer)
+	imageConfig, err := h.validateUpload(imageData, file.Size)
 	if err != nil {
-		return fmt.Errorf("failed to read image")
+		return nil, err
 	}
 
-	contentType := http.DetectContentType(buffer[:n])
-	allowedTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/webp": true,
+	// the slot is held until the upload is stored, the encoded renditions stay in memory until then
+	release, err := h.Pool.Acquire(ctx, services.EstimateProcessingCost(imageConfig.Width, imageConfig.Height, file.Size))
+	if err != nil {
+		return nil, err
 	}
-	if !allowedTypes[contentType] {
-		return fmt.Errorf("unsupported file type")
+	defer release()
+
+	var imageReader io.Reader = imageData
+	if h.UploadPolicy.MaxBytes > 0 {
+		// one byte over the limit is enough to tell the file was too large
+		imageReader = io.LimitReader(imageData, h.UploadPolicy.MaxBytes+1)
 	}
 
-	if seeker, ok := imageData.(io.Seeker); ok {
-		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
-			return fmt.Errorf("failed to reset file pointer")
-		}
-	} else {
-		return fmt.Errorf("file stream not seekable")
+	originalImage, err := io.ReadAll(imageReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image")
+	}
+	if err := h.UploadPolicy.CheckSize(int64(len(originalImage))); err != nil {
+		return nil, err
 	}
 
-	tagsStr := c.PostForm("tags")
-	limitedReader := io.LimitReader(imageData, MaxUploadSize+1)
+	// EXIF read from the file itself is the source of truth, the client only overrides it
+	extractedExif, err := services.ExtractExif(originalImage)
+	if err != nil {
+		log.Printf("[%v]: No usable EXIF in the uploaded file - %v", file.Filename, err)
+	}
+	// the orientation describes the pixels of this exact file, the client may have sent the one of
+	// the original before it resized (and with that already rotated) the image in a canvas
+	ReceivedExif.ImageOrientation = 0
+	finalExif := services.MergeExif(extractedExif, ReceivedExif)
+	log.Printf("[%v]: Using Following EXIF - %v", file.Filename, finalExif)
 
-	webImage, thumbImage, thumbWidth, thumbHeight, err := services.ProcessImage(limitedReader, ReceivedExif.ImageOrientation)
+	processed, err := services.ProcessImage(bytes.NewReader(originalImage), finalExif.ImageOrientation, h.Renditions)
 	if err != nil {
-		return fmt.Errorf("image processing failed")
+		return nil, fmt.Errorf("image processing failed")
+	}
+	progress(models.JobProcessed)
+	// the stored files are upright now, a leftover orientation would make clients rotate them again
+	if services.IsOrientationApplied(finalExif.ImageOrientation) {
+		finalExif.ImageOrientation = 1
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// variables
-	var webURL string
-	var thumbURL string
+	// an upload of the same photo that is still being stored is waited for, so the check below finds it
+	checkDuplicates := h.Duplicates != DuplicatesOff && !fields.AllowDuplicate
+	if h.Duplicates != DuplicatesOff {
+		releaseHash, err := h.inFlight.claim(ctx, processed.PerceptualHash, h.DuplicateThreshold, checkDuplicates)
+		if err != nil {
+			return nil, fmt.Errorf("duplicate check failed: %w", err)
+		}
+		defer releaseHash()
+	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	// checked before anything is written, a rejected duplicate costs no storage calls
+	duplicates := []models.SimilarPhoto{}
+	if checkDuplicates {
+		duplicates, err = h.findSimilar(ctx, processed.PerceptualHash, h.DuplicateThreshold, "", maxDuplicateMatches)
+		if err != nil {
+			log.Printf("[%v]: Could not check for duplicates - %v", file.Filename, err)
+			return nil, fmt.Errorf("duplicate check failed")
+		}
+		if len(duplicates) > 0 && h.Duplicates == DuplicatesReject {
+			return nil, &DuplicateError{Matches: duplicates}
+		}
+	}
 
-	g.Go(func() error {
-		webFileName := services.GenerateUniqueFileName("web")
-		webURL, err = h.R2Service.UploadFile(ctx, webFileName, webImage)
-		return err
-	})
+	webKey := services.GenerateUniqueFileName("web")
+
+	// every rendition of one upload shares the same base name, e.g. renditions/2025/01/02/<uuid>_640.webp
+	renditionBase := services.GenerateUniqueFileName("renditions")
+	renditions := make([]models.Rendition, len(processed.Renditions))
+	for i, renditionImage := range processed.Renditions {
+		renditions[i] = models.Rendition{
+			Key:    services.RenditionFileName(renditionBase, renditionImage.Spec),
+			Width:  renditionImage.Width,
+			Height: renditionImage.Height,
+			Format: renditionImage.Spec.Format,
+		}
+	}
+	thumbnail := renditions[services.ThumbnailRendition(processed.Renditions)]
+
+	// everything written from here on is removed again unless the photo reaches the database
+	uploads := &uploadTracker{storage: h.Storage}
+	committed := false
+	defer func() {
+		if !committed {
+			h.rollbackUploads(uploads, file.Filename)
+		}
+	}()
+
+	g, gctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		thumbFileName := services.GenerateUniqueFileName("thumbnails")
-		thumbURL, err = h.R2Service.UploadFile(ctx, thumbFileName, thumbImage)
-		return err
+		return uploads.upload(gctx, webKey, processed.WebImage)
 	})
 
+	for i, renditionImage := range processed.Renditions {
+		g.Go(func() error {
+			return uploads.upload(gctx, renditions[i].Key, renditionImage.Data)
+		})
+	}
+
 	if err := g.Wait(); err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+		return nil, fmt.Errorf("upload failed: %w", err)
 	}
+	progress(models.JobStored)
 
 	var tags []models.Tag
-	tagNames := strings.SplitSeq(tagsStr, ",")
+	tagNames := strings.SplitSeq(fields.Tags, ",")
 	for name := range tagNames {
 		if strings.TrimSpace(name) != "" {
 			tags = append(tags, models.Tag{Name: strings.TrimSpace(name)})
 		}
 	}
 	photoModel := &models.Photo{
-		ImageURL:     webURL,
-		ThumbnailURL: thumbURL,
-		ThumbWidth:   thumbWidth,
-		ThumbHeight:  thumbHeight,
-		Exif:         ReceivedExif,
-		Tags:         tags,
-		CreatedAt:    time.Now(),
+		ImageKey:       webKey,
+		ThumbnailKey:   thumbnail.Key,
+		ThumbWidth:     thumbnail.Width,
+		ThumbHeight:    thumbnail.Height,
+		BlurHash:       processed.Placeholder.BlurHash,
+		DominantColor:  processed.Placeholder.DominantColor,
+		Title:          strings.TrimSpace(fields.Title),
+		Caption:        strings.TrimSpace(fields.Caption),
+		AltText:        strings.TrimSpace(fields.AltText),
+		Exif:           finalExif,
+		Tags:           tags,
+		Palette:        processed.Palette,
+		PerceptualHash: &processed.PerceptualHash,
+		Renditions:     renditions,
+		CreatedAt:      time.Now(),
+	}
+
+	var photoID string
+	if job == nil {
+		photoID, err = database.CreatePhoto(h.DB, photoModel)
+	} else {
+		indexed := *job
+		indexed.Stage = models.JobIndexed
+		indexed.Duplicates = duplicates
+		indexed.UpdatedAt = time.Now()
+		photoID, err = database.CreatePhotoForJob(h.DB, photoModel, &indexed)
+		if err == nil {
+			*job = indexed
+			h.jobEvents.publish(*job)
+		}
+	}
+	if err != nil {
+		log.Printf("[%v]: Could not write image to database - %v", file.Filename, err)
+		return nil, fmt.Errorf("Could not write image to database")
 	}
+	committed = true
 
-	if _, err := database.CreatePhoto(h.DB, photoModel); err != nil {
-		return fmt.Errorf("Could not write image to database")
+	return &uploadResult{ID: photoID, Duplicates: duplicates}, nil
+}
+
+// photoKeys lists every storage key of a photo: the uploaded image, the thumbnail and all renditions.
+func photoKeys(photo models.Photo) []string {
+	keys := []string{photo.ImageKey, photo.ThumbnailKey}
+	for _, rendition := range photo.Renditions {
+		keys = append(keys, rendition.Key)
 	}
+	return keys
+}
 
-	return nil
+// uploadTracker remembers every key an upload wrote, or tried to write, so a failed upload can
+// remove them again. A failed or timed out PutObject may still have stored the object.
+type uploadTracker struct {
+	storage services.Storage
+
+	mu   sync.Mutex
+	keys []string
 }
 
+func (t *uploadTracker) upload(ctx context.Context, key string, data []byte) error {
+	t.mu.Lock()
+	t.keys = append(t.keys, key)
+	t.mu.Unlock()
+
+	_, err := t.storage.UploadFile(ctx, key, data)
+	return err
+}
+
+// rollbackUploads deletes the files of a failed upload. Files that cannot be deleted right away
+// are queued in failed_storage_deletes for the retry worker, so a failed upload never leaks storage.
+func (h *PhotoHandler) rollbackUploads(uploads *uploadTracker, fileName string) {
+	uploads.mu.Lock()
+	keys := append([]string(nil), uploads.keys...)
+	uploads.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	// the request context may already be cancelled, which is often why the upload failed
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var failedKeys []string
+	for _, key := range keys {
+		if err := h.Storage.DeleteFile(ctx, key); err != nil {
+			log.Printf("[%v]: Could not roll back uploaded file %s - %v", fileName, key, err)
+			failedKeys = append(failedKeys, key)
+		}
+	}
+
+	if len(failedKeys) == 0 {
+		log.Printf("[%v]: Rolled back %d uploaded files", fileName, len(keys))
+		return
+	}
+
+	// one queue row per upload, the keys after the first are stored as its extra_keys
+	failed := models.Photo{
+		ID:       uuid.New().String(),
+		ImageKey: failedKeys[0],
+	}
+	for _, key := range failedKeys[1:] {
+		failed.Renditions = append(failed.Renditions, models.Rendition{Key: key})
+	}
+
+	if err := database.AddToFailedStore(h.DB, ctx, []models.Photo{failed}); err != nil {
+		log.Printf("[%v]: Could not queue %d files for deletion, they are left in storage - %v", fileName, len(failedKeys), err)
+		return
+	}
+	log.Printf("[%v]: Queued %d uploaded files for deletion", fileName, len(failedKeys))
+}
+
+// deleteByIDs permanently deletes the photos in ids that are in the trash, then their files.
 func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.H, err error) {
 	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -462,9 +848,10 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 
 	var snapshotRows []models.Photo
 
+	// only photos still in the trash are deleted, one restored since the IDs were read is kept
 	toDeleteSnapshot := fmt.Sprintf(`
-	SELECT id, image_url, thumbnail_url, created_at
-	FROM photos WHERE id IN (%s)`, strings.Join(placeholders, ","))
+	SELECT id, image_key, thumbnail_key, created_at
+	FROM photos WHERE id IN (%s) AND deleted_at IS NOT NULL`, strings.Join(placeholders, ","))
 
 	toDeleteRows, err := tx.QueryContext(ctx, toDeleteSnapshot, args...)
 	if err != nil {
@@ -478,8 +865,8 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 
 		if scanErr := toDeleteRows.Scan(
 			&p.ID,
-			&p.ImageURL,
-			&p.ThumbnailURL,
+			&p.ImageKey,
+			&p.ThumbnailKey,
 			&p.CreatedAt,
 		); scanErr != nil {
 			resp = gin.H{"error": "An error occured while scanning query output to structs"}
@@ -494,8 +881,19 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 		resp = gin.H{"error": "An error occured while reading the rows of Snapshot query into slice"}
 		return resp, fmt.Errorf("An error occured while reading the rows of Snapshot query into slice - %v", err)
 	}
+	toDeleteRows.Close()
 
-	deletePhotos := fmt.Sprintf("DELETE FROM photos WHERE id IN (%s)", strings.Join(placeholders, ","))
+	// the rendition rows go away with the photos (ON DELETE CASCADE), keep their keys for storage
+	renditions, err := database.GetRenditions(tx, ctx, ids)
+	if err != nil {
+		resp = gin.H{"error": "An error occured while trying to query the renditions to delete"}
+		return resp, fmt.Errorf("An error occured while trying to query the renditions to delete - %v", err)
+	}
+	for i := range snapshotRows {
+		snapshotRows[i].Renditions = renditions[snapshotRows[i].ID]
+	}
+
+	deletePhotos := fmt.Sprintf("DELETE FROM photos WHERE id IN (%s) AND deleted_at IS NOT NULL", strings.Join(placeholders, ","))
 	deletedRes, deleteErr := tx.ExecContext(ctx, deletePhotos, args...)
 	if deleteErr != nil {
 		resp = gin.H{"error": "An error occured while trying to delete the photos from the DB"}
@@ -503,18 +901,7 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 	}
 	rowsDeleted, _ := deletedRes.RowsAffected()
 
-	removeOrphanTags := `
-	DELETE FROM tags
-		WHERE id IN (
-			SELECT t.id
-			FROM tags t
-			WHERE NOT EXISTS (
-				SELECT 1
-				FROM photo_tags pt
-				WHERE pt.tag_id = t.id
-			)
-		);`
-	if _, orphanTagsErr := tx.Exec(removeOrphanTags); orphanTagsErr != nil {
+	if orphanTagsErr := database.DeleteOrphanTags(ctx, tx); orphanTagsErr != nil {
 		resp = gin.H{"error": "Could not delete orphaned tags"}
 		return resp, fmt.Errorf("Could not delete orphaned tags - %v", orphanTagsErr)
 	}
@@ -528,7 +915,7 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 	var failedList []models.Photo
 	var blobDeleted int
 	for _, photo := range snapshotRows {
-		if err := h.deleteBlobs(photo.ImageURL, photo.ThumbnailURL, ctx); err != nil {
+		if err := h.deleteBlobs(photoKeys(photo), ctx); err != nil {
 			failedList = append(failedList, photo)
 			log.Printf("[DELETE] Failed to delete blob - %v", err)
 		} else {
@@ -550,46 +937,27 @@ func (h *PhotoHandler) deleteByIDs(ctx context.Context, ids []string) (resp gin.
 	return resp, nil
 }
 
-func (h *PhotoHandler) deleteBlobs(imageURL string, thumbnailURL string, ctx context.Context) error {
+// deleteBlobs removes every key from storage in parallel. Empty keys are skipped, queued rows of
+// a rolled back upload only carry the files that could not be deleted.
+func (h *PhotoHandler) deleteBlobs(keys []string, ctx context.Context) error {
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error {
-		if imageURL == "" {
-			return fmt.Errorf("ImageURL received is Empty - skipping")
-		}
-		webKey, err := getKeyFromURL(imageURL)
-		if err != nil {
-			return fmt.Errorf("[ERROR]: Failed to parse webKey from Image URL")
-		}
-
-		if webKey == "" {
-			return fmt.Errorf("[ERROR]: webKey is empty so cannot proceed with deletion")
-		}
-
-		log.Printf("[DELETE]: Deleting high-res file: %s", webKey)
-		return h.R2Service.DeleteFile(ctx, webKey)
-	})
-
-	g.Go(func() error {
-		if thumbnailURL == "" {
-			return fmt.Errorf("ThumbnailURL received is Empty - skipping")
-		}
-		thumbKey, err := getKeyFromURL(thumbnailURL)
-		if err != nil {
-			return fmt.Errorf("[ERROR]: Failed to parse thumbKey from Thumbnail URL")
-		}
-
-		if thumbKey == "" {
-			return fmt.Errorf("[ERROR]: thumbKey is empty so cannot proceed with deletion")
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key == "" || seen[key] {
+			continue
 		}
+		seen[key] = true
 
-		log.Printf("[DELETE]: Deleting thumbnail file: %s", thumbKey)
-		return h.R2Service.DeleteFile(ctx, thumbKey)
-	})
+		g.Go(func() error {
+			log.Printf("[DELETE]: Deleting file: %s", key)
+			return h.Storage.DeleteFile(ctx, key)
+		})
+	}
 
 	if err := g.Wait(); err != nil {
-		return fmt.Errorf("Failed to delete image files from R2 - %v", err)
+		return fmt.Errorf("Failed to delete image files from storage - %v", err)
 	}
 
 	return nil
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "secret"
+
+// newTestHandler returns a handler backed by a fresh sqlite database and local storage in a
+// temporary directory, so the upload and delete flows run without any network.
+func newTestHandler(t *testing.T) *PhotoHandler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	db, err := database.OpenDB(filepath.Join(dir, "photos.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	storage, err := services.NewLocalStorage(filepath.Join(dir, "media"), "/media")
+	if err != nil {
+		t.Fatalf("local storage: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	t.Setenv("ADMIN_PASSWORD_HASH", string(hash))
+
+	h := NewPhotoHandler(db, storage)
+	h.SpoolDir = filepath.Join(dir, "spool")
+	return h
+}
+
+// testJPEG encodes a small gradient so every upload decodes and hashes like a real photo.
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func multipartUpload(t *testing.T, fileName string, data []byte, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("image", fileName)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(data)
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	w.Close()
+	return &body, w.FormDataContentType()
+}
+
+// uploadTestPhoto posts data to the upload route at /photos and returns the new photo ID.
+func uploadTestPhoto(t *testing.T, r http.Handler, fileName string, data []byte, fields map[string]string) string {
+	t.Helper()
+	body, contentType := multipartUpload(t, fileName, data, fields)
+	req := httptest.NewRequest(http.MethodPost, "/photos", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var uploaded struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &uploaded); err != nil || uploaded.ID == "" {
+		t.Fatalf("upload response %s has no id (%v)", rec.Body, err)
+	}
+	return uploaded.ID
+}
+
+func doJSON(t *testing.T, r http.Handler, method, target string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadAndDeleteWithLocalStorage(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+	r.DELETE("/photos", h.DeletePhotos)
+	r.DELETE("/trash", h.EmptyTrash)
+
+	id := uploadTestPhoto(t, r, "gradient.jpg", testJPEG(t, 320, 240), map[string]string{"title": "Test photo"})
+
+	photo, err := database.GetPhotoByID(h.DB, id)
+	if err != nil || photo == nil {
+		t.Fatalf("photo %s not stored: %v", id, err)
+	}
+	if photo.Title != "Test photo" {
+		t.Errorf("title = %v, want %q", photo.Title, "Test photo")
+	}
+
+	ctx := context.Background()
+	keys := photoKeys(*photo)
+	for _, key := range keys {
+		if ok, err := h.Storage.FileExists(ctx, key); err != nil || !ok {
+			t.Errorf("file %s missing after upload (exists %v, err %v)", key, ok, err)
+		}
+	}
+
+	rec := doJSON(t, r, http.MethodDelete, "/photos", DeleteRequest{DeleteIDsArray: []string{id}, Password: "wrong"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("delete with wrong password status = %d, want 401", rec.Code)
+	}
+
+	rec = doJSON(t, r, http.MethodDelete, "/photos", DeleteRequest{DeleteIDsArray: []string{id}, Password: testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body %s", rec.Code, rec.Body)
+	}
+	if photo, _ := database.GetPhotoByID(h.DB, id); photo != nil {
+		t.Fatalf("photo %s still listed after moving it to the trash", id)
+	}
+	// trashed photos keep their files until the trash is emptied
+	for _, key := range keys {
+		if ok, _ := h.Storage.FileExists(ctx, key); !ok {
+			t.Errorf("file %s removed while the photo is only in the trash", key)
+		}
+	}
+
+	rec = doJSON(t, r, http.MethodDelete, "/trash", DeleteRequest{Password: testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("empty trash status = %d, body %s", rec.Code, rec.Body)
+	}
+	for _, key := range keys {
+		if ok, err := h.Storage.FileExists(ctx, key); err != nil || ok {
+			t.Errorf("file %s left in storage after emptying the trash (exists %v, err %v)", key, ok, err)
+		}
+	}
+
+	files, err := h.Storage.ListFiles(ctx, "")
+	if err != nil {
+		t.Fatalf("list files: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("storage still holds %d files after emptying the trash", len(files))
+	}
+}
+
+func TestPatchExifClearsNullFields(t *testing.T) {
+	taken := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	base := models.Exif{
+		ISO:             "100",
+		LensModel:       "50mm f/1.8",
+		FocalLength:     50,
+		DateTaken:       &taken,
+		DateTakenOffset: "+02:00",
+		GPS:             &models.GPS{Latitude: 1, Longitude: 2},
+	}
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(`{"iso": "200", "lensModel": null, "focalLength": null, "gps": null}`), &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := patchExif(base, patch)
+	if err != nil {
+		t.Fatalf("patchExif: %v", err)
+	}
+	if got.ISO != "200" {
+		t.Errorf("iso = %q, want 200", got.ISO)
+	}
+	if got.LensModel != "" || got.FocalLength != 0 || got.GPS != nil {
+		t.Errorf("null fields not cleared: lens %q, focal length %v, gps %v", got.LensModel, got.FocalLength, got.GPS)
+	}
+	if got.DateTaken == nil || !got.DateTaken.Equal(taken) || got.DateTakenOffset != "+02:00" {
+		t.Errorf("date taken changed although it was left out: %v %q", got.DateTaken, got.DateTakenOffset)
+	}
+
+	if _, err := patchExif(base, map[string]json.RawMessage{"iso": json.RawMessage(`100`)}); err == nil {
+		t.Error("expected an error for an iso that is not a string")
+	}
+}
+
+func TestDeleteByIDsKeepsRestoredPhotos(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+
+	id := uploadTestPhoto(t, r, "gradient.jpg", testJPEG(t, 320, 240), nil)
+
+	// the photo was restored after the purge read its ID, it is not in the trash anymore
+	if _, err := h.deleteByIDs(context.Background(), []string{id}); err != nil {
+		t.Fatalf("deleteByIDs: %v", err)
+	}
+
+	photo, err := database.GetPhotoByID(h.DB, id)
+	if err != nil || photo == nil {
+		t.Fatalf("photo outside the trash was deleted (err %v)", err)
+	}
+	for _, key := range photoKeys(*photo) {
+		if ok, _ := h.Storage.FileExists(context.Background(), key); !ok {
+			t.Errorf("file %s of a photo outside the trash was deleted", key)
+		}
+	}
+}
+
+func TestUploadIgnoresClientOrientation(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+
+	// a canvas-resized upload is already upright, the orientation the client read from the original must not rotate it again
+	id := uploadTestPhoto(t, r, "resized.jpg", testJPEG(t, 320, 240), map[string]string{"exif": `{"imageOrientation": 6, "iso": "200"}`})
+
+	photo, err := database.GetPhotoByID(h.DB, id)
+	if err != nil || photo == nil {
+		t.Fatalf("photo %s not stored: %v", id, err)
+	}
+	if photo.ThumbWidth <= photo.ThumbHeight {
+		t.Errorf("thumbnail is %dx%d, the landscape upload was rotated", photo.ThumbWidth, photo.ThumbHeight)
+	}
+	if photo.Exif.ISO != "200" {
+		t.Errorf("iso = %q, the other client EXIF values must still apply", photo.Exif.ISO)
+	}
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/admin/processing
+// Queue depth, memory in use and recent wait/processing latencies of the image processing pool.
+func (h *PhotoHandler) GetProcessingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Pool.Stats())
+}
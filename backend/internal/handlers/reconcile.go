@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/services"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultReconcileGracePeriod keeps objects of uploads that are still being written out of the orphan list.
+const DefaultReconcileGracePeriod = time.Hour
+
+var reconcilePrefixes = []string{"web/", "thumbnails/", "renditions/"}
+
+type ReconcileReport struct {
+	ScannedObjects  int                   `json:"scannedObjects"`
+	ReferencedFiles int                   `json:"referencedFiles"`
+	SkippedRecent   int                   `json:"skippedRecent"`
+	OrphanObjects   []services.StoredFile `json:"orphanObjects"`
+	DanglingPhotos  []DanglingPhoto       `json:"danglingPhotos"`
+	Fixed           *ReconcileFixes       `json:"fixed,omitempty"`
+}
+
+// DanglingPhoto is a photo row that points at files which are missing from storage.
+type DanglingPhoto struct {
+	ID          string   `json:"id"`
+	MissingKeys []string `json:"missingKeys"`
+	Trashed     bool     `json:"trashed"`
+}
+
+type ReconcileFixes struct {
+	DeletedObjects int   `json:"deletedObjects"`
+	FailedObjects  int   `json:"failedObjects"`
+	TrashedPhotos  int64 `json:"trashedPhotos"`
+}
+
+type ReconcileRequest struct {
+	Password string `json:"password"`
+}
+
+// GET /api/admin/reconcile
+// Only reports, nothing is changed.
+func (h *PhotoHandler) GetReconcileReport(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.ReconcileStorage(ctx, false, DefaultReconcileGracePeriod)
+	if err != nil {
+		log.Printf("[RECONCILE:ERROR] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile storage with the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// POST /api/admin/reconcile
+// Deletes orphan objects and moves dangling photos to the trash.
+func (h *PhotoHandler) FixReconcile(c *gin.Context) {
+	var reconcileRequest ReconcileRequest
+	if bindError := c.ShouldBindJSON(&reconcileRequest); bindError != nil {
+		log.Printf("[RECONCILE:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(os.Getenv("ADMIN_PASSWORD_HASH")), []byte(reconcileRequest.Password))
+	if err != nil {
+		log.Println("[RECONCILE:ERROR]: Wrong Password Entered")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong Password"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.ReconcileStorage(ctx, true, DefaultReconcileGracePeriod)
+	if err != nil {
+		log.Printf("[RECONCILE:ERROR] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile storage with the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ReconcileStorage compares the objects under web/, thumbnails/ and renditions/ with the keys referenced by
+// photos (trashed ones included) and by the failed delete queue. Objects nobody references are
+// orphans, photos whose files are missing are dangling. Orphans younger than gracePeriod are
+// skipped since their upload may not have reached the database yet.
+// With fix, orphans are deleted from storage and dangling photos are moved to the trash.
+func (h *PhotoHandler) ReconcileStorage(ctx context.Context, fix bool, gracePeriod time.Duration) (*ReconcileReport, error) {
+	// read the database before listing storage, an upload that lands in between then shows up
+	// as a recent object instead of a photo whose files are missing
+	photoFiles, err := database.GetAllPhotoFiles(h.DB, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not read photos - %v", err)
+	}
+	failedDeletes, err := database.GetFailedDeletes(h.DB, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not read failed_storage_deletes - %v", err)
+	}
+
+	stored := make(map[string]services.StoredFile)
+	for _, prefix := range reconcilePrefixes {
+		files, err := h.Storage.ListFiles(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("could not list %s - %v", prefix, err)
+		}
+		for _, file := range files {
+			stored[file.Key] = file
+		}
+	}
+
+	report := &ReconcileReport{
+		ScannedObjects: len(stored),
+		OrphanObjects:  []services.StoredFile{},
+		DanglingPhotos: []DanglingPhoto{},
+	}
+
+	referenced := make(map[string]bool)
+	for _, pf := range photoFiles {
+		var missing []string
+		checked := make(map[string]bool)
+		for _, key := range append([]string{pf.ImageKey, pf.ThumbnailKey}, pf.RenditionKeys...) {
+			// the thumbnail is usually one of the renditions
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+
+			referenced[key] = true
+			if _, ok := stored[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+
+		if len(missing) > 0 {
+			report.DanglingPhotos = append(report.DanglingPhotos, DanglingPhoto{ID: pf.ID, MissingKeys: missing, Trashed: pf.Trashed})
+		}
+	}
+	report.ReferencedFiles = len(referenced)
+
+	// files waiting in the retry queue are already on their way out
+	for _, fd := range failedDeletes {
+		for _, key := range append([]string{fd.ImageKey, fd.ThumbnailKey}, fd.ExtraKeys...) {
+			if key != "" {
+				referenced[key] = true
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-gracePeriod)
+	for key, file := range stored {
+		if referenced[key] {
+			continue
+		}
+		if file.LastModified.After(cutoff) {
+			report.SkippedRecent++
+			continue
+		}
+		report.OrphanObjects = append(report.OrphanObjects, file)
+	}
+	sort.Slice(report.OrphanObjects, func(i, j int) bool {
+		return report.OrphanObjects[i].Key < report.OrphanObjects[j].Key
+	})
+
+	log.Printf("[RECONCILE] Scanned %d objects - %d orphans, %d dangling photos, %d recent objects skipped",
+		report.ScannedObjects, len(report.OrphanObjects), len(report.DanglingPhotos), report.SkippedRecent)
+
+	if !fix {
+		return report, nil
+	}
+
+	report.Fixed = &ReconcileFixes{}
+	for _, orphan := range report.OrphanObjects {
+		if err := h.Storage.DeleteFile(ctx, orphan.Key); err != nil {
+			log.Printf("[RECONCILE:ERROR] Could not delete orphan object %s - %v", orphan.Key, err)
+			report.Fixed.FailedObjects++
+			continue
+		}
+		report.Fixed.DeletedObjects++
+	}
+
+	var danglingIDs []string
+	for _, dp := range report.DanglingPhotos {
+		if !dp.Trashed {
+			danglingIDs = append(danglingIDs, dp.ID)
+		}
+	}
+	trashed, err := database.TrashPhotos(h.DB, ctx, danglingIDs)
+	if err != nil {
+		return report, fmt.Errorf("could not move dangling photos to the trash - %v", err)
+	}
+	report.Fixed.TrashedPhotos = trashed
+
+	log.Printf("[RECONCILE] Deleted %d orphan objects (%d failed), moved %d dangling photos to the trash",
+		report.Fixed.DeletedObjects, report.Fixed.FailedObjects, report.Fixed.TrashedPhotos)
+	return report, nil
+}
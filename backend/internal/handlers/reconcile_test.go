@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReconcileStorageFix(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+	ctx := context.Background()
+
+	id := uploadTestPhoto(t, r, "gradient.jpg", testJPEG(t, 800, 600), nil)
+	photo, err := database.GetPhotoByID(h.DB, id)
+	if err != nil || photo == nil {
+		t.Fatalf("photo %s not stored: %v", id, err)
+	}
+
+	// one rendition that is not the thumbnail goes missing, the photo is dangling from then on
+	var missingKey string
+	for _, rendition := range photo.Renditions {
+		if rendition.Key != photo.ThumbnailKey {
+			missingKey = rendition.Key
+			break
+		}
+	}
+	if missingKey == "" {
+		t.Fatalf("upload produced no rendition besides the thumbnail: %+v", photo.Renditions)
+	}
+	if err := h.Storage.DeleteFile(ctx, missingKey); err != nil {
+		t.Fatalf("delete rendition: %v", err)
+	}
+
+	const orphanKey = "web/2020/01/01/orphan.webp"
+	queuedKeys := []string{"web/2020/01/01/queued.webp", "renditions/2020/01/01/queued_640.webp"}
+	for _, key := range append([]string{orphanKey}, queuedKeys...) {
+		if _, err := h.Storage.UploadFile(ctx, key, []byte("old object")); err != nil {
+			t.Fatalf("upload %s: %v", key, err)
+		}
+	}
+	err = database.AddToFailedStore(h.DB, ctx, []models.Photo{{
+		ID:         "queued",
+		ImageKey:   queuedKeys[0],
+		Renditions: []models.Rendition{{Key: queuedKeys[1]}},
+	}})
+	if err != nil {
+		t.Fatalf("queue failed delete: %v", err)
+	}
+
+	// a grace period of 0 treats every object written so far as old enough to be an orphan
+	report, err := h.ReconcileStorage(ctx, true, 0)
+	if err != nil {
+		t.Fatalf("ReconcileStorage: %v", err)
+	}
+
+	var orphans []string
+	for _, orphan := range report.OrphanObjects {
+		orphans = append(orphans, orphan.Key)
+	}
+	if want := []string{orphanKey}; !slices.Equal(orphans, want) {
+		t.Errorf("orphans = %v, want %v", orphans, want)
+	}
+	if len(report.DanglingPhotos) != 1 || report.DanglingPhotos[0].ID != id ||
+		!slices.Equal(report.DanglingPhotos[0].MissingKeys, []string{missingKey}) {
+		t.Errorf("dangling photos = %+v, want %s missing %s", report.DanglingPhotos, id, missingKey)
+	}
+	if report.Fixed == nil || report.Fixed.DeletedObjects != 1 || report.Fixed.FailedObjects != 0 || report.Fixed.TrashedPhotos != 1 {
+		t.Fatalf("fixed = %+v, want 1 object deleted and 1 photo trashed", report.Fixed)
+	}
+
+	if ok, _ := h.Storage.FileExists(ctx, orphanKey); ok {
+		t.Errorf("orphan %s was not deleted", orphanKey)
+	}
+	for _, key := range append(photoKeys(*photo), queuedKeys...) {
+		if key == missingKey {
+			continue
+		}
+		if ok, err := h.Storage.FileExists(ctx, key); err != nil || !ok {
+			t.Errorf("referenced file %s was deleted (exists %v, err %v)", key, ok, err)
+		}
+	}
+
+	if photo, _ := database.GetPhotoByID(h.DB, id); photo != nil {
+		t.Errorf("dangling photo %s is still listed, want it in the trash", id)
+	}
+	trashed, err := database.GetAllPhotoFiles(h.DB, ctx)
+	if err != nil {
+		t.Fatalf("GetAllPhotoFiles: %v", err)
+	}
+	if len(trashed) != 1 || !trashed[0].Trashed {
+		t.Errorf("photo rows = %+v, want the dangling photo kept in the trash", trashed)
+	}
+}
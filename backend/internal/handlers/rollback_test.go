@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flakyStorage fails the failUpload-th UploadFile call and, with failDeletes, every DeleteFile.
+type flakyStorage struct {
+	services.Storage
+	failUpload  int
+	failDeletes bool
+
+	mu       sync.Mutex
+	uploaded []string
+}
+
+func (s *flakyStorage) UploadFile(ctx context.Context, key string, data []byte) (string, error) {
+	s.mu.Lock()
+	s.uploaded = append(s.uploaded, key)
+	call := len(s.uploaded)
+	s.mu.Unlock()
+
+	if call == s.failUpload {
+		return "", errors.New("storage unavailable")
+	}
+	return s.Storage.UploadFile(ctx, key, data)
+}
+
+func (s *flakyStorage) DeleteFile(ctx context.Context, key string) error {
+	if s.failDeletes {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.DeleteFile(ctx, key)
+}
+
+// uploadWithFlakyStorage posts a photo while the second storage write fails and returns the
+// keys the upload tried to write.
+func uploadWithFlakyStorage(t *testing.T, h *PhotoHandler, failDeletes bool) []string {
+	t.Helper()
+	storage := &flakyStorage{Storage: h.Storage, failUpload: 2, failDeletes: failDeletes}
+	h.Storage = storage
+	r := gin.New()
+	r.POST("/photos", h.UploadPhoto)
+
+	body, contentType := multipartUpload(t, "gradient.jpg", testJPEG(t, 800, 600), nil)
+	req := httptest.NewRequest(http.MethodPost, "/photos", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code == http.StatusCreated {
+		t.Fatalf("upload succeeded although a storage write failed: %s", rec.Body)
+	}
+
+	var photos int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM photos`).Scan(&photos); err != nil {
+		t.Fatal(err)
+	}
+	if photos != 0 {
+		t.Errorf("%d photo rows left after a failed upload", photos)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploaded) < 3 {
+		t.Fatalf("upload wrote %v, want the web image and at least two renditions", storage.uploaded)
+	}
+	return slices.Clone(storage.uploaded)
+}
+
+func TestFailedUploadRemovesItsFiles(t *testing.T) {
+	h := newTestHandler(t)
+	uploadWithFlakyStorage(t, h, false)
+
+	for _, prefix := range []string{"web/", "renditions/", "thumbnails/"} {
+		files, err := h.Storage.ListFiles(context.Background(), prefix)
+		if err != nil {
+			t.Fatalf("list %s: %v", prefix, err)
+		}
+		if len(files) != 0 {
+			t.Errorf("%d files left under %s after a failed upload: %+v", len(files), prefix, files)
+		}
+	}
+
+	queued, err := database.GetFailedDeletes(h.DB, context.Background())
+	if err != nil {
+		t.Fatalf("GetFailedDeletes: %v", err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("rollback queued %+v although every delete succeeded", queued)
+	}
+}
+
+func TestFailedRollbackQueuesItsFiles(t *testing.T) {
+	h := newTestHandler(t)
+	attempted := uploadWithFlakyStorage(t, h, true)
+
+	queued, err := database.GetFailedDeletes(h.DB, context.Background())
+	if err != nil {
+		t.Fatalf("GetFailedDeletes: %v", err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("queued %d rows, want one row for the upload", len(queued))
+	}
+	if len(queued[0].ExtraKeys) == 0 {
+		t.Errorf("no extra_keys stored, only %q was queued", queued[0].ImageKey)
+	}
+
+	var keys []string
+	for _, key := range append([]string{queued[0].ImageKey, queued[0].ThumbnailKey}, queued[0].ExtraKeys...) {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	slices.Sort(attempted)
+	if !slices.Equal(keys, attempted) {
+		t.Errorf("queued keys %v, want every key the upload wrote %v", keys, attempted)
+	}
+}
@@ -11,15 +11,45 @@ func RegisterRoutes(router *gin.Engine, h *PhotoHandler, userApiKey string) {
 	{
 		api.GET("/photos", h.GetAllPhotos)
 		api.GET("/photos/:id", h.GetPhotoByID)
+		api.GET("/photos/:id/image", h.GetPhotoImage)
+		api.GET("/photos/:id/similar", h.GetSimilarPhotos)
+		api.GET("/tags", h.GetAllTags)
+		api.GET("/albums", h.GetAllAlbums)
+		api.GET("/albums/:slug", h.GetAlbumBySlug)
 		api.POST("/admin/login", h.LoginAdmin)
 		api.GET("/admin/me", h.CheckAdmin)
 		admin := api.Group("/admin")
 		{
 			admin.Use(middleware.AuthMiddleware())
 			admin.POST("/photos", h.UploadPhoto)
+			admin.POST("/photos/batch", h.UploadPhotoBatch)
+			admin.PATCH("/photos/:id", h.UpdatePhoto)
 			admin.DELETE("/photos", h.DeletePhotos)
 			admin.DELETE("/photos/all", h.DeleteAllPhotos)
+			admin.GET("/photos/failed", h.GetFailedDeletes)
+			admin.GET("/processing", h.GetProcessingStats)
+			admin.GET("/jobs/:id", h.GetUploadJob)
+			admin.GET("/jobs/:id/events", h.StreamUploadJob)
+			admin.OPTIONS("/uploads", h.TusOptions)
+			admin.POST("/uploads", h.CreateTusUpload)
+			admin.HEAD("/uploads/:id", h.GetTusUploadOffset)
+			admin.PATCH("/uploads/:id", h.PatchTusUpload)
+			admin.DELETE("/uploads/:id", h.DeleteTusUpload)
 			admin.DELETE("/photos/failed", h.NukeFailedBlobs)
+			admin.GET("/reconcile", h.GetReconcileReport)
+			admin.POST("/reconcile", h.FixReconcile)
+			admin.GET("/trash", h.GetTrash)
+			admin.POST("/trash/restore", h.RestorePhotos)
+			admin.DELETE("/trash", h.EmptyTrash)
+			admin.PATCH("/tags/:id", h.RenameTag)
+			admin.POST("/tags/merge", h.MergeTags)
+			admin.DELETE("/tags/:id", h.DeleteTag)
+			admin.POST("/albums", h.CreateAlbum)
+			admin.PATCH("/albums/:id", h.UpdateAlbum)
+			admin.DELETE("/albums/:id", h.DeleteAlbum)
+			admin.POST("/albums/:id/photos", h.AddPhotosToAlbum)
+			admin.DELETE("/albums/:id/photos", h.RemovePhotosFromAlbum)
+			admin.PUT("/albums/:id/order", h.ReorderAlbumPhotos)
 		}
 	}
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DuplicatePolicy decides what happens to an upload that looks like a photo already stored.
+type DuplicatePolicy string
+
+const (
+	DuplicatesOff    DuplicatePolicy = "off"
+	DuplicatesWarn   DuplicatePolicy = "warn"
+	DuplicatesReject DuplicatePolicy = "reject"
+)
+
+const (
+	// DefaultDuplicateThreshold is the largest Hamming distance an upload counts as a duplicate at.
+	// Re-encodes and resizes of the same shot stay well below it, different frames of a burst rarely do.
+	DefaultDuplicateThreshold = 6
+	// DefaultSimilarDistance is the largest distance GET /photos/:id/similar returns by default.
+	DefaultSimilarDistance = 16
+	// maxDuplicateMatches is how many near-duplicates an upload reports.
+	maxDuplicateMatches = 5
+)
+
+// ParseDuplicatePolicy reads DUPLICATE_POLICY, an empty value means warn.
+func ParseDuplicatePolicy(value string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return DuplicatesWarn, nil
+	case DuplicatesOff, DuplicatesWarn, DuplicatesReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%q is not one of off, warn or reject", value)
+	}
+}
+
+// DuplicateError rejects an upload under DuplicatesReject.
+type DuplicateError struct {
+	Matches []models.SimilarPhoto
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("image looks like %d photo(s) already uploaded", len(e.Matches))
+}
+
+// GET /api/photos/:id/similar?limit=12&maxDistance=16
+// Photos that look like this one, the most alike first. Photos uploaded before perceptual hashes
+// were computed have no similar photos.
+func (h *PhotoHandler) GetSimilarPhotos(c *gin.Context) {
+	id := c.Param("id")
+
+	limit, err := boundedQueryInt(c, "limit", 12, 1, 50)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxDistance, err := boundedQueryInt(c, "maxDistance", DefaultSimilarDistance, 0, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := database.GetPhotoHash(h.DB, c.Request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo Not Found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[SIMILAR] Could not read the hash of photo (%s) - %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find similar photos"})
+		return
+	}
+	if hash == nil {
+		c.JSON(http.StatusOK, gin.H{"photos": []models.SimilarPhoto{}})
+		return
+	}
+
+	similar, err := h.findSimilar(c.Request.Context(), *hash, maxDistance, id, limit)
+	if err != nil {
+		log.Printf("[SIMILAR] Could not find photos similar to (%s) - %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find similar photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"photos": similar})
+}
+
+// findSimilar returns up to limit photos whose hash is at most maxDistance bits away from hash,
+// closest first. excludeID leaves out the photo the hash belongs to.
+func (h *PhotoHandler) findSimilar(ctx context.Context, hash uint64, maxDistance int, excludeID string, limit int) ([]models.SimilarPhoto, error) {
+	hashes, err := database.GetPhotoHashes(h.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		id       string
+		distance int
+	}
+	var matches []match
+	for _, candidate := range hashes {
+		if candidate.ID == excludeID {
+			continue
+		}
+		if distance := services.HammingDistance(hash, candidate.Hash); distance <= maxDistance {
+			matches = append(matches, match{id: candidate.ID, distance: distance})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].id < matches[j].id
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	thumbnails, err := database.GetThumbnailPhotos(h.DB, ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	similar := make([]models.SimilarPhoto, 0, len(matches))
+	for _, m := range matches {
+		thumbnail, ok := thumbnails[m.id]
+		if !ok {
+			// trashed since the hashes were read
+			continue
+		}
+		h.withThumbnailURL(&thumbnail)
+		similar = append(similar, models.SimilarPhoto{ThumbnailPhoto: thumbnail, Distance: m.distance})
+	}
+	return similar, nil
+}
+
+// inFlightHashes holds the perceptual hashes of uploads that passed the duplicate check but are not
+// in the database yet. Without it two copies of a photo in one batch, or in concurrent upload jobs,
+// would both pass the check.
+type inFlightHashes struct {
+	mu      sync.Mutex
+	uploads map[*inFlightUpload]struct{}
+}
+
+type inFlightUpload struct {
+	hash uint64
+	done chan struct{}
+}
+
+// claim registers hash as in flight. With wait set it first waits until no upload within
+// maxDistance of hash is in flight anymore, so the caller's duplicate check sees that upload
+// in the database. release must be called once the upload is in the database or has failed.
+func (f *inFlightHashes) claim(ctx context.Context, hash uint64, maxDistance int, wait bool) (release func(), err error) {
+	for {
+		f.mu.Lock()
+		if f.uploads == nil {
+			f.uploads = make(map[*inFlightUpload]struct{})
+		}
+
+		var busy chan struct{}
+		if wait {
+			for upload := range f.uploads {
+				if services.HammingDistance(hash, upload.hash) <= maxDistance {
+					busy = upload.done
+					break
+				}
+			}
+		}
+
+		if busy == nil {
+			upload := &inFlightUpload{hash: hash, done: make(chan struct{})}
+			f.uploads[upload] = struct{}{}
+			f.mu.Unlock()
+
+			return func() {
+				f.mu.Lock()
+				delete(f.uploads, upload)
+				f.mu.Unlock()
+				close(upload.done)
+			}, nil
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// boundedQueryInt reads an optional whole number query parameter within [minValue, maxValue].
+func boundedQueryInt(c *gin.Context, key string, fallback, minValue, maxValue int) (int, error) {
+	raw := strings.TrimSpace(c.Query(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < minValue || value > maxValue {
+		return 0, fmt.Errorf("%s must be a whole number between %d and %d", key, minValue, maxValue)
+	}
+	return value, nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shutterdev/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInFlightHashesClaim(t *testing.T) {
+	var inFlight inFlightHashes
+	ctx := context.Background()
+
+	release, err := inFlight.claim(ctx, 0b1111, 2, true)
+	if err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+
+	// a distant hash, or a caller that does not check for duplicates, never waits
+	if other, err := inFlight.claim(ctx, ^uint64(0), 2, true); err != nil {
+		t.Fatalf("distant claim: %v", err)
+	} else {
+		other()
+	}
+	if allowed, err := inFlight.claim(ctx, 0b1111, 2, false); err != nil {
+		t.Fatalf("claim without waiting: %v", err)
+	} else {
+		allowed()
+	}
+
+	// a near duplicate waits until the first upload is done
+	claimed := make(chan struct{})
+	go func() {
+		if second, err := inFlight.claim(ctx, 0b0111, 2, true); err == nil {
+			second()
+		}
+		close(claimed)
+	}()
+	select {
+	case <-claimed:
+		t.Fatal("near duplicate was claimed while the first upload was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-claimed:
+	case <-time.After(time.Second):
+		t.Fatal("near duplicate still waiting after the first upload was released")
+	}
+
+	// a cancelled caller stops waiting
+	release, _ = inFlight.claim(ctx, 1, 0, true)
+	defer release()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := inFlight.claim(cancelled, 1, 0, true); err == nil {
+		t.Error("claim returned without error for a cancelled context")
+	}
+}
+
+func TestBatchRejectsDuplicatesWithinTheBatch(t *testing.T) {
+	h := newTestHandler(t)
+	h.Duplicates = DuplicatesReject
+	h.Pool = services.NewProcessingPool(services.PoolConfig{Concurrency: 3, MemoryBudget: 512 << 20, QueueSize: 8, QueueTimeout: time.Minute})
+	r := gin.New()
+	r.POST("/photos/batch", h.UploadPhotoBatch)
+
+	image := testJPEG(t, 320, 240)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		part, err := w.CreateFormFile("image", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/photos/batch", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var resp struct {
+		Created int `json:"created"`
+		Results []struct {
+			Status int    `json:"status"`
+			Code   string `json:"code"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %s: %v", rec.Body, err)
+	}
+	if resp.Created != 1 {
+		t.Fatalf("%d copies of the same image were stored, want 1 (body %s)", resp.Created, rec.Body)
+	}
+	for _, result := range resp.Results {
+		if result.Status != http.StatusCreated && result.Code != "duplicate" {
+			t.Errorf("result %+v, want created or duplicate", result)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"shutterdev/backend/internal/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RenameTagRequest struct {
+	Name string `json:"tagName"`
+}
+
+type MergeTagsRequest struct {
+	SourceIDs []int  `json:"sourceIds"`
+	TargetID  int    `json:"targetId"`
+	Name      string `json:"tagName"`
+}
+
+// GET /api/tags
+func (h *PhotoHandler) GetAllTags(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	tags, err := database.GetAllTags(h.DB, ctx, false)
+	if err != nil {
+		log.Printf("[TAGS:ERROR] Could not fetch tags - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// PATCH /api/admin/tags/:id
+func (h *PhotoHandler) RenameTag(c *gin.Context) {
+	tagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag id"})
+		return
+	}
+
+	var renameRequest RenameTagRequest
+	if bindError := c.ShouldBindJSON(&renameRequest); bindError != nil {
+		log.Printf("[TAGS:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	newName := strings.TrimSpace(renameRequest.Name)
+	if newName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name cannot be empty"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := database.RenameTag(h.DB, ctx, tagID, newName); err != nil {
+		respondTagError(c, "rename", err)
+		return
+	}
+
+	log.Printf("[TAGS] Renamed tag (%d) to %q", tagID, newName)
+	c.JSON(http.StatusOK, gin.H{"id": tagID, "tagName": newName})
+}
+
+// POST /api/admin/tags/merge
+func (h *PhotoHandler) MergeTags(c *gin.Context) {
+	var mergeRequest MergeTagsRequest
+	if bindError := c.ShouldBindJSON(&mergeRequest); bindError != nil {
+		log.Printf("[TAGS:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	if mergeRequest.TargetID == 0 || len(mergeRequest.SourceIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "targetId and at least one sourceId are required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	newName := strings.TrimSpace(mergeRequest.Name)
+	if err := database.MergeTags(h.DB, ctx, mergeRequest.SourceIDs, mergeRequest.TargetID, newName); err != nil {
+		respondTagError(c, "merge", err)
+		return
+	}
+
+	log.Printf("[TAGS] Merged tags %v into (%d)", mergeRequest.SourceIDs, mergeRequest.TargetID)
+
+	tags, err := database.GetAllTags(h.DB, ctx, true)
+	if err != nil {
+		log.Printf("[TAGS:ERROR] Could not fetch tags - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// DELETE /api/admin/tags/:id
+func (h *PhotoHandler) DeleteTag(c *gin.Context) {
+	tagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := database.DeleteTag(h.DB, ctx, tagID); err != nil {
+		respondTagError(c, "delete", err)
+		return
+	}
+
+	log.Printf("[TAGS] Deleted tag (%d) from all photos", tagID)
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted"})
+}
+
+func respondTagError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag Not Found"})
+	case errors.Is(err, database.ErrTagNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another tag already uses this name, merge the tags instead"})
+	default:
+		log.Printf("[TAGS:ERROR] Could not %s tag - %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " tag"})
+	}
+}
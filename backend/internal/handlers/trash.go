@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"shutterdev/backend/internal/database"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type RestoreRequest struct {
+	RestoreIDsArray []string `json:"RestoreIDs"`
+}
+
+// GET /api/admin/trash
+func (h *PhotoHandler) GetTrash(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	trashed, err := database.GetTrashedPhotos(h.DB, ctx)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] Could not fetch the trash - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the trash"})
+		return
+	}
+
+	for i := range trashed {
+		trashed[i].ThumbnailURL = h.Storage.PublicURL(trashed[i].ThumbnailKey)
+		if h.TrashRetention > 0 {
+			purgeAt := trashed[i].DeletedAt.Add(h.TrashRetention)
+			trashed[i].PurgeAt = &purgeAt
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"photos":        trashed,
+		"retentionDays": int(h.TrashRetention / (24 * time.Hour)),
+	})
+}
+
+// POST /api/admin/trash/restore
+func (h *PhotoHandler) RestorePhotos(c *gin.Context) {
+	var restoreRequest RestoreRequest
+	if bindError := c.ShouldBindJSON(&restoreRequest); bindError != nil {
+		log.Printf("[TRASH:ERROR] Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	if len(restoreRequest.RestoreIDsArray) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "0 Photos recieved to restore"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	restored, err := database.RestorePhotos(h.DB, ctx, restoreRequest.RestoreIDsArray)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] Could not restore photos - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not restore photos"})
+		return
+	}
+
+	log.Printf("[TRASH] Restored %d photos", restored)
+	c.JSON(http.StatusOK, gin.H{"restored": restored})
+}
+
+// DELETE /api/admin/trash
+// Permanently deletes the photos in DeleteIDs, or the whole trash when DeleteIDs is empty.
+func (h *PhotoHandler) EmptyTrash(c *gin.Context) {
+	var deleteRequest DeleteRequest
+	if bindError := c.ShouldBindJSON(&deleteRequest); bindError != nil {
+		log.Printf("[ERROR]: Could not bind request.Body to internal struct - %v", bindError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not bind request.Body to internal struct"})
+		return
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(os.Getenv("ADMIN_PASSWORD_HASH")), []byte(deleteRequest.Password))
+	if err != nil {
+		log.Println("[TRASH:ERROR]: Wrong Password Entered")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong Password"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	// only photos that are actually in the trash can be deleted permanently
+	toDeleteIds, err := database.GetTrashedPhotoIDs(h.DB, ctx, time.Time{}, deleteRequest.DeleteIDsArray)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] Could not get the IDs of photos in the trash - %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get the IDs of photos in the trash"})
+		return
+	}
+
+	if len(toDeleteIds) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"db_deleted": 0,
+			"storage": gin.H{
+				"success": 0,
+				"failed":  0,
+			},
+		})
+		return
+	}
+
+	resp, err := h.deleteByIDs(ctx, toDeleteIds)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] %v", err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RunTrashPurger permanently deletes photos that have been in the trash longer than
+// TrashRetention, checking every interval until ctx is cancelled.
+func (h *PhotoHandler) RunTrashPurger(ctx context.Context, interval time.Duration) {
+	if h.TrashRetention <= 0 {
+		log.Println("[TRASH] Automatic purge disabled")
+		return
+	}
+
+	log.Printf("[TRASH] Purging photos older than %v every %v", h.TrashRetention, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.purgeTrash(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *PhotoHandler) purgeTrash(ctx context.Context) {
+	purgeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	expiredIds, err := database.GetTrashedPhotoIDs(h.DB, purgeCtx, time.Now().Add(-h.TrashRetention), nil)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] Could not get the IDs of expired photos - %v", err)
+		return
+	}
+	if len(expiredIds) == 0 {
+		return
+	}
+
+	resp, err := h.deleteByIDs(purgeCtx, expiredIds)
+	if err != nil {
+		log.Printf("[TRASH:ERROR] Purge failed - %v", err)
+		return
+	}
+
+	log.Printf("[TRASH] Purged expired photos - %v", resp)
+}
@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+	"shutterdev/backend/internal/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// TusVersion is the only tus protocol version the upload endpoint speaks.
+	TusVersion = "1.0.0"
+	// DefaultTusExpiry is how long an unfinished upload is kept after its last chunk.
+	DefaultTusExpiry = 24 * time.Hour
+
+	tusExtensions  = "creation,creation-with-upload,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// errTusChunkTooLarge is a chunk that would write past Upload-Length.
+var errTusChunkTooLarge = errors.New("chunk is larger than the rest of the upload")
+
+// OPTIONS /api/admin/uploads
+// Reports the tus version, extensions and largest upload the server supports.
+func (h *PhotoHandler) TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", TusVersion)
+	c.Header("Tus-Version", TusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	if h.UploadPolicy.MaxBytes > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(h.UploadPolicy.MaxBytes, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/admin/uploads
+// Creates a resumable upload of Upload-Length bytes. Upload-Metadata may carry filename, title,
+// caption, altText, tags, exif and allowDuplicate, like the form fields of POST /admin/photos.
+// A body sent with Content-Type application/offset+octet-stream is the first chunk.
+func (h *PhotoHandler) CreateTusUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Defer-Length is not supported, send Upload-Length"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length must be a number of bytes"})
+		return
+	}
+
+	metadata := c.GetHeader("Upload-Metadata")
+	values, err := parseTusMetadata(metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fileName := tusFileName(values)
+
+	// oversized and empty files are turned away before a single byte is sent
+	if err := h.UploadPolicy.CheckSize(length); err != nil {
+		status, body := h.uploadErrorResponse(fileName, err)
+		c.JSON(status, body)
+		return
+	}
+	if length == 0 {
+		status, body := h.uploadErrorResponse(fileName, services.UnreadableImage(fmt.Errorf("file is empty")))
+		c.JSON(status, body)
+		return
+	}
+
+	now := time.Now()
+	upload := &models.TusUpload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.TusExpiry),
+	}
+	upload.SpoolPath = filepath.Join(h.SpoolDir, upload.ID)
+
+	if err := spoolFile(upload.SpoolPath, strings.NewReader("")); err != nil {
+		log.Printf("[TUS:ERROR] Could not create %s - %v", upload.SpoolPath, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the upload"})
+		return
+	}
+	if err := database.CreateTusUpload(h.DB, c.Request.Context(), upload); err != nil {
+		log.Printf("[TUS:ERROR] %v", err)
+		os.Remove(upload.SpoolPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the upload"})
+		return
+	}
+	log.Printf("[TUS] Created upload %s for %v (%d bytes)", upload.ID, fileName, length)
+
+	c.Header("Location", "/api/admin/uploads/"+upload.ID)
+	if c.ContentType() != tusContentType {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Status(http.StatusCreated)
+		return
+	}
+
+	if !h.lockTusUpload(upload.ID) {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return
+	}
+	defer h.unlockTusUpload(upload.ID)
+	h.receiveTusChunk(c, upload, 0, http.StatusCreated)
+}
+
+// HEAD /api/admin/uploads/:id
+// Upload-Offset is how many bytes arrived, the client resumes from there.
+func (h *PhotoHandler) GetTusUploadOffset(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	upload, ok := h.loadTusUpload(c)
+	if !ok {
+		return
+	}
+	offset, err := tusOffset(upload)
+	if err != nil {
+		log.Printf("[TUS:ERROR] Could not read the offset of %s - %v", upload.ID, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	if upload.CompletedAt == nil {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Status(http.StatusOK)
+}
+
+// PATCH /api/admin/uploads/:id
+// Appends a chunk at Upload-Offset. The chunk that completes the upload hands it to the
+// asynchronous processing of GET /admin/jobs/:id, under the same ID.
+func (h *PhotoHandler) PatchTusUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be a number of bytes"})
+		return
+	}
+
+	// a client retrying a chunk while the first attempt is still being read must not interleave.
+	// The upload is loaded under the lock, another request may just have completed it
+	if !h.lockTusUpload(c.Param("id")) {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return
+	}
+	defer h.unlockTusUpload(c.Param("id"))
+
+	upload, ok := h.loadTusUpload(c)
+	if !ok {
+		return
+	}
+	h.receiveTusChunk(c, upload, offset, http.StatusNoContent)
+}
+
+// DELETE /api/admin/uploads/:id
+// Abandons an unfinished upload. Completed uploads belong to their job and cannot be removed.
+func (h *PhotoHandler) DeleteTusUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	if !h.lockTusUpload(c.Param("id")) {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return
+	}
+	defer h.unlockTusUpload(c.Param("id"))
+
+	upload, ok := h.loadTusUpload(c)
+	if !ok {
+		return
+	}
+	if upload.CompletedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is complete, it is processed as job " + upload.ID})
+		return
+	}
+
+	h.removeTusUploads(c.Request.Context(), []models.TusUpload{*upload})
+	log.Printf("[TUS] Upload %s terminated", upload.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// receiveTusChunk appends the request body to an upload whose lock is held, expecting it to start
+// at offset, and answers with successStatus, or with the result of completing the upload.
+func (h *PhotoHandler) receiveTusChunk(c *gin.Context, upload *models.TusUpload, offset int64, successStatus int) {
+	current, err := tusOffset(upload)
+	if err != nil {
+		log.Printf("[TUS:ERROR] Could not read the offset of %s - %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read the upload"})
+		return
+	}
+	if offset != current {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload-Offset is %d, the upload is at %d", offset, current)})
+		return
+	}
+
+	if upload.CompletedAt == nil {
+		current, err = appendTusChunk(upload, current, c.Request.Body, c.Request.ContentLength)
+		if errors.Is(err, errTusChunkTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			// whatever arrived before the connection broke is kept, the client resumes after it
+			log.Printf("[TUS] Upload %s interrupted at %d of %d bytes - %v", upload.ID, current, upload.Length, err)
+		}
+
+		// the request context is cancelled when the client went away, the chunk still counts
+		upload.ExpiresAt = time.Now().Add(h.TusExpiry)
+		if err := database.ExtendTusUpload(h.DB, context.WithoutCancel(c.Request.Context()), upload.ID, upload.ExpiresAt); err != nil {
+			log.Printf("[TUS:ERROR] %v", err)
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+	if current < upload.Length {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Status(successStatus)
+		return
+	}
+	if upload.CompletedAt != nil {
+		c.Status(successStatus)
+		return
+	}
+
+	if err := h.completeTusUpload(c.Request.Context(), upload); err != nil {
+		status, body := h.uploadErrorResponse(tusFileName(mustParseTusMetadata(upload.Metadata)), err)
+		c.JSON(status, body)
+		return
+	}
+	c.Status(successStatus)
+}
+
+// completeTusUpload validates a fully received upload and starts the job that processes it.
+// An upload that breaks the UploadPolicy is removed, its error is returned to the client.
+func (h *PhotoHandler) completeTusUpload(ctx context.Context, upload *models.TusUpload) error {
+	values := mustParseTusMetadata(upload.Metadata)
+
+	spooled, err := os.Open(upload.SpoolPath)
+	if err != nil {
+		return fmt.Errorf("could not open the upload: %v", err)
+	}
+	_, err = h.validateUpload(spooled, upload.Length)
+	spooled.Close()
+
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		h.removeTusUploads(ctx, []models.TusUpload{*upload})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := uploadFields{
+		Title:          values["title"],
+		Caption:        values["caption"],
+		AltText:        values["altText"],
+		Tags:           values["tags"],
+		Exif:           values["exif"],
+		AllowDuplicate: values["allowDuplicate"] == "true",
+	}
+	encodedFields, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	job := &models.UploadJob{
+		ID:        upload.ID,
+		FileName:  tusFileName(values),
+		Stage:     models.JobReceived,
+		Fields:    string(encodedFields),
+		SpoolPath: upload.SpoolPath,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	// stored before the job is queued, once the job removes the spooled file the upload must
+	// already be complete. The row stays until it expires, so HEAD keeps reporting the upload as complete
+	if err := database.CompleteTusUploadWithJob(h.DB, ctx, upload.ID, now, job); err != nil {
+		return err
+	}
+	upload.CompletedAt = &now
+	h.queueJob(job)
+	log.Printf("[TUS] Upload %s complete after %v", upload.ID, now.Sub(upload.CreatedAt))
+	return nil
+}
+
+// expireTusUploads removes uploads whose expiry passed, with the files of the unfinished ones.
+func (h *PhotoHandler) expireTusUploads(ctx context.Context) {
+	expired, err := database.GetExpiredTusUploads(h.DB, ctx, time.Now())
+	if err != nil {
+		log.Printf("[TUS:ERROR] Could not fetch expired uploads - %v", err)
+		return
+	}
+
+	var removable []models.TusUpload
+	for _, upload := range expired {
+		// an upload receiving a chunk right now is left for the next pass
+		if h.lockTusUpload(upload.ID) {
+			removable = append(removable, upload)
+		}
+	}
+	h.removeTusUploads(ctx, removable)
+	for _, upload := range removable {
+		h.unlockTusUpload(upload.ID)
+	}
+	if len(removable) > 0 {
+		log.Printf("[TUS] Removed %d expired uploads", len(removable))
+	}
+}
+
+// removeTusUploads deletes uploads and the files of those not handed to a job yet.
+func (h *PhotoHandler) removeTusUploads(ctx context.Context, uploads []models.TusUpload) {
+	ids := make([]string, len(uploads))
+	for i, upload := range uploads {
+		ids[i] = upload.ID
+		if upload.CompletedAt != nil {
+			continue
+		}
+		if err := os.Remove(upload.SpoolPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[TUS:ERROR] Could not remove %s - %v", upload.SpoolPath, err)
+		}
+	}
+	if err := database.DeleteTusUploads(h.DB, ctx, ids); err != nil {
+		log.Printf("[TUS:ERROR] %v", err)
+	}
+}
+
+// loadTusUpload fetches the upload of the :id param, answering 404 or 410 when there is none.
+func (h *PhotoHandler) loadTusUpload(c *gin.Context) (*models.TusUpload, bool) {
+	upload, err := database.GetTusUpload(h.DB, c.Request.Context(), c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload Not Found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[TUS:ERROR] Could not fetch upload %s - %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Fetch upload"})
+		return nil, false
+	}
+	if upload.CompletedAt == nil && time.Now().After(upload.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+		return nil, false
+	}
+	return upload, true
+}
+
+func (h *PhotoHandler) lockTusUpload(id string) bool {
+	h.tusMu.Lock()
+	defer h.tusMu.Unlock()
+	if h.tusBusy == nil {
+		h.tusBusy = make(map[string]struct{})
+	}
+	if _, busy := h.tusBusy[id]; busy {
+		return false
+	}
+	h.tusBusy[id] = struct{}{}
+	return true
+}
+
+func (h *PhotoHandler) unlockTusUpload(id string) {
+	h.tusMu.Lock()
+	defer h.tusMu.Unlock()
+	delete(h.tusBusy, id)
+}
+
+// checkTusResumable answers 412 to clients that speak another tus version. Every tus response
+// carries the Tus-Resumable header.
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", TusVersion)
+	if c.GetHeader("Tus-Resumable") != TusVersion {
+		c.Header("Tus-Version", TusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Tus-Resumable must be " + TusVersion})
+		return false
+	}
+	return true
+}
+
+// tusOffset is how many bytes of an upload arrived. The spooled file is the source of truth, its
+// size survives a restart; a completed upload's file belongs to its job and may be gone already.
+func tusOffset(upload *models.TusUpload) (int64, error) {
+	if upload.CompletedAt != nil {
+		return upload.Length, nil
+	}
+	info, err := os.Stat(upload.SpoolPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// appendTusChunk writes body to the end of the upload and returns the new offset, also when the
+// body broke off part way.
+func appendTusChunk(upload *models.TusUpload, offset int64, body io.Reader, contentLength int64) (int64, error) {
+	remaining := upload.Length - offset
+	if contentLength > remaining {
+		return offset, errTusChunkTooLarge
+	}
+
+	spooled, err := os.OpenFile(upload.SpoolPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return offset, err
+	}
+	written, copyErr := io.Copy(spooled, io.LimitReader(body, remaining))
+	if err := spooled.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	return offset + written, copyErr
+}
+
+// parseTusMetadata reads an Upload-Metadata header: comma separated keys, each followed by a
+// space and its base64 encoded value, e.g. "filename cGhvdG8uanBn,tags".
+func parseTusMetadata(header string) (map[string]string, error) {
+	values := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %s is not base64", key)
+		}
+		values[key] = string(value)
+	}
+	return values, nil
+}
+
+// mustParseTusMetadata reads metadata that was already accepted when the upload was created.
+func mustParseTusMetadata(header string) map[string]string {
+	values, err := parseTusMetadata(header)
+	if err != nil {
+		return map[string]string{}
+	}
+	return values
+}
+
+// tusFileName is the name the client gave the file, tus clients send it as filename or name.
+func tusFileName(values map[string]string) string {
+	for _, key := range []string{"filename", "name"} {
+		if name := strings.TrimSpace(values[key]); name != "" {
+			return filepath.Base(name)
+		}
+	}
+	return "upload"
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"shutterdev/backend/internal/database"
+	"shutterdev/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"key and value", "filename cGhvdG8uanBn", map[string]string{"filename": "photo.jpg"}, false},
+		{"key without value", "filename cGhvdG8uanBn,allowDuplicate", map[string]string{"filename": "photo.jpg", "allowDuplicate": ""}, false},
+		{"spaces around pairs", " title U3Vuc2V0 , tags c2t5LHNlYQ== ", map[string]string{"title": "Sunset", "tags": "sky,sea"}, false},
+		{"unicode value", "title w7xiZXIgZGVuIFdvbGtlbg==", map[string]string{"title": "über den Wolken"}, false},
+		{"empty pairs are skipped", "filename cGhvdG8uanBn,,", map[string]string{"filename": "photo.jpg"}, false},
+		{"not base64", "filename photo.jpg", nil, true},
+		{"url-safe base64", "title __8=", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTusMetadata(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTusMetadata(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTusMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingReader returns its data and then an error, like a connection that broke mid-chunk.
+type failingReader struct {
+	data string
+	read bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(p, r.data), nil
+}
+
+func TestAppendTusChunk(t *testing.T) {
+	spoolPath := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(spoolPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	upload := &models.TusUpload{ID: "upload", Length: 10, SpoolPath: spoolPath}
+
+	offset, err := appendTusChunk(upload, 0, strings.NewReader("0123"), 4)
+	if err != nil || offset != 4 {
+		t.Fatalf("first chunk: offset %d, err %v, want 4", offset, err)
+	}
+
+	// a chunk that announces more than the upload has left is refused before anything is written
+	offset, err = appendTusChunk(upload, 4, strings.NewReader("4567890"), 7)
+	if !errors.Is(err, errTusChunkTooLarge) || offset != 4 {
+		t.Fatalf("oversized chunk: offset %d, err %v, want 4 and errTusChunkTooLarge", offset, err)
+	}
+
+	// bytes that arrived before the connection broke are kept
+	offset, err = appendTusChunk(upload, 4, &failingReader{data: "456"}, 6)
+	if err == nil || offset != 7 {
+		t.Fatalf("broken chunk: offset %d, err %v, want 7 and an error", offset, err)
+	}
+
+	// without a Content-Length nothing past the upload length is written
+	offset, err = appendTusChunk(upload, 7, strings.NewReader("789 and more"), -1)
+	if err != nil || offset != 10 {
+		t.Fatalf("last chunk: offset %d, err %v, want 10", offset, err)
+	}
+
+	data, err := os.ReadFile(spoolPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789" {
+		t.Errorf("spooled file is %q, want %q", data, "0123456789")
+	}
+
+	missing := &models.TusUpload{ID: "missing", Length: 10, SpoolPath: filepath.Join(t.TempDir(), "missing")}
+	if offset, err := appendTusChunk(missing, 0, strings.NewReader("0"), 1); err == nil || offset != 0 {
+		t.Errorf("missing spool file: offset %d, err %v, want 0 and an error", offset, err)
+	}
+}
+
+// tusRequest sends a tus request with the protocol header set and optional extra headers.
+func tusRequest(r http.Handler, method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", TusVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", tusContentType)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTusUploadBecomesAJob(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/uploads", h.CreateTusUpload)
+	r.HEAD("/uploads/:id", h.GetTusUploadOffset)
+	r.PATCH("/uploads/:id", h.PatchTusUpload)
+	r.DELETE("/uploads/:id", h.DeleteTusUpload)
+
+	data := testJPEG(t, 320, 240)
+	half := len(data) / 2
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("resumed.jpg")) +
+		",title " + base64.StdEncoding.EncodeToString([]byte("Resumed"))
+
+	rec := tusRequest(r, http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": metadata,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	id := path.Base(location)
+	target := "/uploads/" + id
+
+	rec = tusRequest(r, http.MethodPatch, target, data[:half], map[string]string{"Upload-Offset": "0"})
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk status = %d, offset %q, body %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+
+	// a retried chunk that already arrived is refused, nothing is appended twice
+	rec = tusRequest(r, http.MethodPatch, target, data[:half], map[string]string{"Upload-Offset": "0"})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("chunk at a wrong offset status = %d, want 409", rec.Code)
+	}
+
+	rec = tusRequest(r, http.MethodHead, target, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) ||
+		rec.Header().Get("Upload-Length") != strconv.Itoa(len(data)) || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("HEAD status = %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = tusRequest(r, http.MethodPatch, target, data[half:], map[string]string{"Upload-Offset": strconv.Itoa(half)})
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Fatalf("last chunk status = %d, offset %q, body %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+
+	// the job and the completed upload are stored before the job can run
+	ctx := context.Background()
+	upload, err := database.GetTusUpload(h.DB, ctx, id)
+	if err != nil || upload.CompletedAt == nil {
+		t.Fatalf("upload %s not marked complete (err %v)", id, err)
+	}
+	job, err := database.GetUploadJob(h.DB, ctx, id)
+	if err != nil || job.Stage != models.JobReceived {
+		t.Fatalf("job %s not received (err %v)", id, err)
+	}
+
+	h.runUploadJob(ctx, id)
+	job, err = database.GetUploadJob(h.DB, ctx, id)
+	if err != nil || job.Stage != models.JobIndexed || job.PhotoID == "" {
+		t.Fatalf("job = %+v (err %v), want indexed with a photo", job, err)
+	}
+	photo, err := database.GetPhotoByID(h.DB, job.PhotoID)
+	if err != nil || photo == nil || photo.Title != "Resumed" {
+		t.Fatalf("photo of the job = %+v (err %v), want the title from the metadata", photo, err)
+	}
+
+	// the spooled file is gone now, HEAD still answers from the completed row
+	rec = tusRequest(r, http.MethodHead, target, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) || rec.Header().Get("Upload-Expires") != "" {
+		t.Errorf("HEAD of the completed upload status = %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = tusRequest(r, http.MethodDelete, target, nil, nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("DELETE of the completed upload status = %d, want 409", rec.Code)
+	}
+}
+
+func TestTusCompletionFailureKeepsTheUploadResumable(t *testing.T) {
+	h := newTestHandler(t)
+	r := gin.New()
+	r.POST("/uploads", h.CreateTusUpload)
+	r.HEAD("/uploads/:id", h.GetTusUploadOffset)
+	r.PATCH("/uploads/:id", h.PatchTusUpload)
+
+	data := testJPEG(t, 320, 240)
+	rec := tusRequest(r, http.MethodPost, "/uploads", nil, map[string]string{"Upload-Length": strconv.Itoa(len(data))})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body %s", rec.Code, rec.Body)
+	}
+	id := path.Base(rec.Header().Get("Location"))
+	target := "/uploads/" + id
+
+	if _, err := h.DB.Exec(`CREATE TRIGGER reject_completion BEFORE UPDATE OF completed_at ON tus_uploads BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatal(err)
+	}
+	rec = tusRequest(r, http.MethodPatch, target, data, map[string]string{"Upload-Offset": "0"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("completion that could not be stored status = %d, want 500", rec.Code)
+	}
+	// no job may take over the spooled file of an upload that still looks unfinished
+	ctx := context.Background()
+	if upload, err := database.GetTusUpload(h.DB, ctx, id); err != nil || upload.CompletedAt != nil {
+		t.Fatalf("upload = %+v (err %v), want it left unfinished", upload, err)
+	}
+	if _, err := database.GetUploadJob(h.DB, ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("job lookup error = %v, want no job", err)
+	}
+
+	// the bytes are all there, an empty chunk at the end retries the completion
+	if _, err := h.DB.Exec(`DROP TRIGGER reject_completion`); err != nil {
+		t.Fatal(err)
+	}
+	rec = tusRequest(r, http.MethodHead, target, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Fatalf("HEAD status = %d, offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	rec = tusRequest(r, http.MethodPatch, target, []byte{}, map[string]string{"Upload-Offset": strconv.Itoa(len(data))})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("retried completion status = %d, body %s", rec.Code, rec.Body)
+	}
+	if job, err := database.GetUploadJob(h.DB, ctx, id); err != nil || job.Stage != models.JobReceived {
+		t.Fatalf("job %s not created by the retry (err %v)", id, err)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"shutterdev/backend/internal/models"
+	"strings"
+)
+
+// The database only stores storage keys. Public URLs are built on every response from the
+// current storage configuration, so a new bucket domain or CDN never requires touching rows.
+
+func (h *PhotoHandler) withPhotoURLs(photo *models.Photo) {
+	photo.ImageURL = h.Storage.PublicURL(photo.ImageKey)
+	photo.ThumbnailURL = h.Storage.PublicURL(photo.ThumbnailKey)
+	photo.Renditions, photo.SrcSet = h.withRenditionURLs(photo.Renditions)
+}
+
+func (h *PhotoHandler) withThumbnailURLs(photos []models.ThumbnailPhoto) {
+	for i := range photos {
+		h.withThumbnailURL(&photos[i])
+	}
+}
+
+func (h *PhotoHandler) withThumbnailURL(photo *models.ThumbnailPhoto) {
+	photo.ThumbnailURL = h.Storage.PublicURL(photo.ThumbnailKey)
+	photo.Renditions, photo.SrcSet = h.withRenditionURLs(photo.Renditions)
+}
+
+func (h *PhotoHandler) withAlbumURLs(album *models.Album) {
+	if album.CoverPhoto != nil {
+		h.withThumbnailURL(album.CoverPhoto)
+	}
+}
+
+// withRenditionURLs fills the URL of every rendition and builds one srcset per format.
+// Renditions are sorted by width already, so each srcset lists the smallest candidate first.
+func (h *PhotoHandler) withRenditionURLs(renditions []models.Rendition) ([]models.Rendition, map[string]string) {
+	if renditions == nil {
+		renditions = []models.Rendition{}
+	}
+
+	candidates := make(map[string][]string)
+	for i := range renditions {
+		renditions[i].URL = h.Storage.PublicURL(renditions[i].Key)
+		candidates[renditions[i].Format] = append(candidates[renditions[i].Format], fmt.Sprintf("%s %dw", renditions[i].URL, renditions[i].Width))
+	}
+
+	srcSet := make(map[string]string, len(candidates))
+	for format, list := range candidates {
+		srcSet[format] = strings.Join(list, ", ")
+	}
+	return renditions, srcSet
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type Album struct {
+	ID           string          `json:"id"`
+	Slug         string          `json:"slug"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	CoverPhotoID *string         `json:"coverPhotoId"`
+	CoverPhoto   *ThumbnailPhoto `json:"coverPhoto"`
+	PhotoCount   int             `json:"photoCount"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    *time.Time      `json:"updatedAt"`
+}
+
+type AlbumCursor struct {
+	Position int    `json:"position"`
+	ID       string `json:"id"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// JobStage is how far an asynchronous upload got. Indexed and failed are final.
+type JobStage string
+
+const (
+	// JobReceived: the original is spooled to disk and waits for the processing pool
+	JobReceived JobStage = "received"
+	// JobProcessed: the web image, renditions and placeholders are encoded
+	JobProcessed JobStage = "processed"
+	// JobStored: every file is written to storage
+	JobStored JobStage = "stored"
+	// JobIndexed: the photo is in the database and visible
+	JobIndexed JobStage = "indexed"
+	JobFailed  JobStage = "failed"
+)
+
+// Done reports whether the job will not change anymore.
+func (s JobStage) Done() bool {
+	return s == JobIndexed || s == JobFailed
+}
+
+// UploadJob is an upload accepted with 202 and processed in the background.
+type UploadJob struct {
+	ID         string         `json:"id"`
+	FileName   string         `json:"fileName"`
+	Stage      JobStage       `json:"stage"`
+	PhotoID    string         `json:"photoId,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Code       string         `json:"code,omitempty"`
+	Duplicates []SimilarPhoto `json:"duplicates,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+	// SpoolPath is where the original waits until it is processed
+	SpoolPath string `json:"-"`
+	// Fields are the form fields sent with the image, as JSON
+	Fields string `json:"-"`
+}
+
+// TusUpload is a resumable upload in progress. Once all Length bytes arrived it becomes the
+// UploadJob with the same ID.
+type TusUpload struct {
+	ID     string
+	Length int64
+	// Metadata is the Upload-Metadata header the upload was created with
+	Metadata    string
+	SpoolPath   string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	CompletedAt *time.Time
+}
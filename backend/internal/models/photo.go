@@ -3,32 +3,118 @@ package models
 import "time"
 
 type Exif struct {
-	ShutterSpeed     string `json:"shutterSpeed"`
-	Aperture         string `json:"aperture"`
-	ISO              string `json:"iso"`
-	ImageOrientation int    `json:"imageOrientation"`
+	ShutterSpeed         string     `json:"shutterSpeed"`
+	Aperture             string     `json:"aperture"`
+	ISO                  string     `json:"iso"`
+	ImageOrientation     int        `json:"imageOrientation"`
+	CameraMake           string     `json:"cameraMake"`
+	CameraModel          string     `json:"cameraModel"`
+	LensModel            string     `json:"lensModel"`
+	FocalLength          float64    `json:"focalLength"`
+	FocalLength35mm      int        `json:"focalLength35mm"`
+	ExposureCompensation float64    `json:"exposureCompensation"`
+	Flash                string     `json:"flash"`
+	DateTaken            *time.Time `json:"dateTaken"`
+	DateTakenOffset      string     `json:"dateTakenOffset"`
+	GPS                  *GPS       `json:"gps"`
+}
+
+type GPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude"`
 }
 
 type Photo struct {
-	ID           string    `json:"id"`
-	ImageURL     string    `json:"imageUrl"`
-	ThumbnailURL string    `json:"thumbnailUrl"`
-	ThumbWidth   int       `json:"thumbWidth"`
-	ThumbHeight  int       `json:"thumbHeight"`
-	Exif         Exif      `json:"exif"`
-	Tags         []Tag     `json:"tags"`
-	CreatedAt    time.Time `json:"createdAt"`
+	ID            string `json:"id"`
+	ImageKey      string `json:"-"`
+	ThumbnailKey  string `json:"-"`
+	ImageURL      string `json:"imageUrl"`
+	ThumbnailURL  string `json:"thumbnailUrl"`
+	ThumbWidth    int    `json:"thumbWidth"`
+	ThumbHeight   int    `json:"thumbHeight"`
+	BlurHash      string `json:"blurHash"`
+	DominantColor string `json:"dominantColor"`
+	// PerceptualHash is nil for photos uploaded before hashes were computed
+	PerceptualHash *uint64           `json:"-"`
+	Title          string            `json:"title"`
+	Caption        string            `json:"caption"`
+	AltText        string            `json:"altText"`
+	Exif           Exif              `json:"exif"`
+	Tags           []Tag             `json:"tags"`
+	Palette        []PaletteColor    `json:"palette"`
+	Renditions     []Rendition       `json:"renditions"`
+	SrcSet         map[string]string `json:"srcset"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	UpdatedAt      *time.Time        `json:"updatedAt"`
 }
 
 type ThumbnailPhoto struct {
-	ID           string    `json:"id"`
-	ThumbnailURL string    `json:"thumbnailUrl"`
-	ThumbWidth   int       `json:"thumbWidth"`
-	ThumbHeight  int       `json:"thumbHeight"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            string            `json:"id"`
+	ThumbnailKey  string            `json:"-"`
+	ThumbnailURL  string            `json:"thumbnailUrl"`
+	ThumbWidth    int               `json:"thumbWidth"`
+	ThumbHeight   int               `json:"thumbHeight"`
+	BlurHash      string            `json:"blurHash"`
+	DominantColor string            `json:"dominantColor"`
+	Renditions    []Rendition       `json:"renditions"`
+	SrcSet        map[string]string `json:"srcset"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// Rendition is one resized copy of a photo. SrcSet on the photo groups them per format,
+// e.g. {"webp": "<url> 320w, <url> 640w"}.
+type Rendition struct {
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+}
+
+// SimilarPhoto is a photo that looks like another one. Distance is the Hamming distance of
+// their perceptual hashes, 0 for the same picture.
+type SimilarPhoto struct {
+	ThumbnailPhoto
+	Distance int `json:"distance"`
+}
+
+// PaletteColor is one colour of a photo's palette. Weight is the share of the image it covers (0-1).
+// L, A and B are the colour in CIELAB, which the colour search measures distances in.
+type PaletteColor struct {
+	Color  string  `json:"color"`
+	Weight float64 `json:"weight"`
+	L      float64 `json:"-"`
+	A      float64 `json:"-"`
+	B      float64 `json:"-"`
 }
 
 type Cursor struct {
 	CreatedAt time.Time `json:"created_at"`
 	ID        string    `json:"id"`
 }
+
+type TrashedPhoto struct {
+	ID           string     `json:"id"`
+	ThumbnailKey string     `json:"-"`
+	ThumbnailURL string     `json:"thumbnailUrl"`
+	ThumbWidth   int        `json:"thumbWidth"`
+	ThumbHeight  int        `json:"thumbHeight"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeletedAt    time.Time  `json:"deletedAt"`
+	PurgeAt      *time.Time `json:"purgeAt"`
+}
+
+// FailedDelete is a row of failed_storage_deletes, files that could not be removed from storage yet.
+type FailedDelete struct {
+	ID            string     `json:"id"`
+	ImageKey      string     `json:"imageKey"`
+	ThumbnailKey  string     `json:"thumbnailKey"`
+	ExtraKeys     []string   `json:"extraKeys"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError"`
+	CreatedAt     *time.Time `json:"createdAt"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt"`
+	GaveUpAt      *time.Time `json:"gaveUpAt"`
+}
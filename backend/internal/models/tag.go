@@ -4,3 +4,9 @@ type Tag struct {
 	ID   int    `json:"id"`
 	Name string `json:"tagName"`
 }
+
+type TagWithCount struct {
+	ID         int    `json:"id"`
+	Name       string `json:"tagName"`
+	PhotoCount int    `json:"photoCount"`
+}
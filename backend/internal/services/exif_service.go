@@ -0,0 +1,347 @@
+// read the EXIF block of the uploaded original so uploads do not depend on the browser sending it
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+
+	"shutterdev/backend/internal/models"
+)
+
+// Field names for the EXIF 2.31 timezone tags, which goexif does not know about.
+const (
+	OffsetTime         exif.FieldName = "OffsetTime"
+	OffsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+var offsetTimeFields = map[uint16]exif.FieldName{
+	0x9010: OffsetTime,
+	0x9011: OffsetTimeOriginal,
+}
+
+type offsetTimeParser struct{}
+
+// OffsetTimeParser loads the OffsetTime tags from the EXIF sub-IFD.
+// Register it with exif.RegisterParsers before any makernote parsers.
+var OffsetTimeParser exif.Parser = offsetTimeParser{}
+
+// Parse never fails so that a broken sub-IFD does not stop the remaining parsers.
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	subDir, err := exifSubIFD(x)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(subDir, offsetTimeFields, false)
+	return nil
+}
+
+// exifSubIFD decodes the EXIF sub-IFD directly from the raw block.
+func exifSubIFD(x *exif.Exif) (*tiff.Dir, error) {
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	subDir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil, err
+	}
+	return subDir, nil
+}
+
+// exifFocalLength reads FocalLength (0x920A). The Canon makernote parser registers its own
+// FocalLength under the same name and replaces the standard rational value, so fall back
+// to reading the tag straight from the EXIF sub-IFD when that happened.
+func exifFocalLength(x *exif.Exif) (float64, bool) {
+	if focalLength, ok := exifRatFloat(x, exif.FocalLength); ok {
+		return focalLength, true
+	}
+
+	subDir, err := exifSubIFD(x)
+	if err != nil {
+		return 0, false
+	}
+	for _, tag := range subDir.Tags {
+		if tag.Id != 0x920A {
+			continue
+		}
+		rat, err := tag.Rat(0)
+		if err != nil {
+			return 0, false
+		}
+		focalLength, _ := rat.Float64()
+		return focalLength, true
+	}
+	return 0, false
+}
+
+// ExtractExif decodes the EXIF block embedded in the original image bytes.
+// Images without EXIF (most PNGs and WebPs) return an empty models.Exif and the decode error.
+// Individual missing tags are not an error, their fields are simply left empty.
+func ExtractExif(imageData []byte) (models.Exif, error) {
+	var result models.Exif
+
+	x, err := exif.Decode(bytes.NewReader(imageData))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return result, fmt.Errorf("could not decode EXIF: %w", err)
+	}
+
+	if fNumber, ok := exifRatFloat(x, exif.FNumber); ok && fNumber > 0 {
+		result.Aperture = formatAperture(fNumber)
+	}
+
+	if exposure, ok := exifRat(x, exif.ExposureTime); ok && exposure.Sign() > 0 {
+		result.ShutterSpeed = formatShutterSpeed(exposure)
+	}
+
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil && iso > 0 {
+			result.ISO = strconv.Itoa(iso)
+		}
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orientation, err := tag.Int(0); err == nil && orientation >= 1 && orientation <= 8 {
+			result.ImageOrientation = orientation
+		}
+	}
+
+	result.CameraMake = exifString(x, exif.Make)
+	result.CameraModel = exifString(x, exif.Model)
+	result.LensModel = exifString(x, exif.LensModel)
+
+	if focalLength, ok := exifFocalLength(x); ok && focalLength > 0 {
+		result.FocalLength = math.Round(focalLength*10) / 10
+	}
+
+	if tag, err := x.Get(exif.FocalLengthIn35mmFilm); err == nil {
+		if focal35, err := tag.Int(0); err == nil && focal35 > 0 {
+			result.FocalLength35mm = focal35
+		}
+	}
+
+	if bias, ok := exifRatFloat(x, exif.ExposureBiasValue); ok {
+		result.ExposureCompensation = math.Round(bias*100) / 100
+	}
+
+	if tag, err := x.Get(exif.Flash); err == nil {
+		if flash, err := tag.Int(0); err == nil {
+			result.Flash = describeFlash(flash)
+		}
+	}
+
+	result.DateTaken, result.DateTakenOffset = exifDateTaken(x)
+
+	if lat, long, err := x.LatLong(); err == nil && !(lat == 0 && long == 0) {
+		gps := &models.GPS{
+			Latitude:  lat,
+			Longitude: long,
+		}
+		if altitude, ok := exifRatFloat(x, exif.GPSAltitude); ok {
+			if tag, err := x.Get(exif.GPSAltitudeRef); err == nil {
+				// 1 means the altitude is below sea level
+				if ref, err := tag.Int(0); err == nil && ref == 1 {
+					altitude = -altitude
+				}
+			}
+			altitude = math.Round(altitude*10) / 10
+			gps.Altitude = &altitude
+		}
+		result.GPS = gps
+	}
+
+	return result, nil
+}
+
+// MergeExif returns base with every non-empty field of override applied on top of it.
+// This lets the client correct values without having to resend the whole block.
+func MergeExif(base models.Exif, override models.Exif) models.Exif {
+	merged := base
+
+	if override.ShutterSpeed != "" {
+		merged.ShutterSpeed = override.ShutterSpeed
+	}
+	if override.Aperture != "" {
+		merged.Aperture = override.Aperture
+	}
+	if override.ISO != "" {
+		merged.ISO = override.ISO
+	}
+	if override.ImageOrientation != 0 {
+		merged.ImageOrientation = override.ImageOrientation
+	}
+	if override.CameraMake != "" {
+		merged.CameraMake = override.CameraMake
+	}
+	if override.CameraModel != "" {
+		merged.CameraModel = override.CameraModel
+	}
+	if override.LensModel != "" {
+		merged.LensModel = override.LensModel
+	}
+	if override.FocalLength != 0 {
+		merged.FocalLength = override.FocalLength
+	}
+	if override.FocalLength35mm != 0 {
+		merged.FocalLength35mm = override.FocalLength35mm
+	}
+	if override.ExposureCompensation != 0 {
+		merged.ExposureCompensation = override.ExposureCompensation
+	}
+	if override.Flash != "" {
+		merged.Flash = override.Flash
+	}
+	if override.DateTaken != nil {
+		merged.DateTaken = override.DateTaken
+		merged.DateTakenOffset = override.DateTakenOffset
+	}
+	if override.GPS != nil {
+		merged.GPS = override.GPS
+	}
+
+	return merged
+}
+
+// ClearExifField resets the field with the given JSON name, e.g. "lensModel", to its zero value.
+// Clearing dateTaken also clears its offset. Unknown names are ignored.
+func ClearExifField(exif *models.Exif, field string) {
+	switch field {
+	case "shutterSpeed":
+		exif.ShutterSpeed = ""
+	case "aperture":
+		exif.Aperture = ""
+	case "iso":
+		exif.ISO = ""
+	case "imageOrientation":
+		exif.ImageOrientation = 0
+	case "cameraMake":
+		exif.CameraMake = ""
+	case "cameraModel":
+		exif.CameraModel = ""
+	case "lensModel":
+		exif.LensModel = ""
+	case "focalLength":
+		exif.FocalLength = 0
+	case "focalLength35mm":
+		exif.FocalLength35mm = 0
+	case "exposureCompensation":
+		exif.ExposureCompensation = 0
+	case "flash":
+		exif.Flash = ""
+	case "dateTaken":
+		exif.DateTaken = nil
+		exif.DateTakenOffset = ""
+	case "dateTakenOffset":
+		exif.DateTakenOffset = ""
+	case "gps":
+		exif.GPS = nil
+	}
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	val, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(val, "\x00"))
+}
+
+func exifRat(x *exif.Exif, name exif.FieldName) (*big.Rat, bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return nil, false
+	}
+	rat, err := tag.Rat(0)
+	if err != nil {
+		return nil, false
+	}
+	return rat, true
+}
+
+func exifRatFloat(x *exif.Exif, name exif.FieldName) (float64, bool) {
+	rat, ok := exifRat(x, name)
+	if !ok {
+		return 0, false
+	}
+	f, _ := rat.Float64()
+	return f, true
+}
+
+// exifDateTaken reads DateTimeOriginal (falling back to DateTime) and pairs it with
+// OffsetTimeOriginal when the camera recorded one. Without an offset the wall clock time
+// is kept as UTC and the returned offset is empty, meaning "unknown timezone".
+func exifDateTaken(x *exif.Exif) (*time.Time, string) {
+	dateStr := exifString(x, exif.DateTimeOriginal)
+	offsetName := OffsetTimeOriginal
+	if dateStr == "" {
+		dateStr = exifString(x, exif.DateTime)
+		offsetName = OffsetTime
+	}
+	if dateStr == "" {
+		return nil, ""
+	}
+
+	location := time.UTC
+	offsetStr := exifString(x, offsetName)
+	if offsetStr != "" {
+		if zoned, err := time.Parse("-07:00", offsetStr); err == nil {
+			_, seconds := zoned.Zone()
+			location = time.FixedZone("", seconds)
+		} else {
+			offsetStr = ""
+		}
+	}
+
+	taken, err := time.ParseInLocation("2006:01:02 15:04:05", dateStr, location)
+	if err != nil || taken.IsZero() {
+		return nil, ""
+	}
+
+	return &taken, offsetStr
+}
+
+// describeFlash turns the EXIF Flash bit field into "fired" or "not fired".
+// Cameras without a flash unit (bit 5 set) return an empty string.
+func describeFlash(flash int) string {
+	if flash&0x20 != 0 {
+		return ""
+	}
+	if flash&0x1 != 0 {
+		return "fired"
+	}
+	return "not fired"
+}
+
+// formatAperture matches what the upload page sends, e.g. "f/2.8" or "f/8".
+func formatAperture(fNumber float64) string {
+	return "f/" + strconv.FormatFloat(math.Round(fNumber*10)/10, 'f', -1, 64)
+}
+
+// formatShutterSpeed renders fractions of a second as "1/250" and long exposures as "2.5s".
+func formatShutterSpeed(exposure *big.Rat) string {
+	seconds, _ := exposure.Float64()
+	if seconds >= 1 {
+		return strconv.FormatFloat(math.Round(seconds*10)/10, 'f', -1, 64) + "s"
+	}
+	return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+
+	"shutterdev/backend/internal/models"
+)
+
+// TIFF field types used by the test blocks below.
+const (
+	tiffShort    = 3
+	tiffASCII    = 2
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag, tiffASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortEntry(tag uint16, v uint16) tiffEntry {
+	return tiffEntry{tag, tiffShort, 1, binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func rationalEntry(tag uint16, num, den uint32) tiffEntry {
+	value := binary.LittleEndian.AppendUint32(nil, num)
+	return tiffEntry{tag, tiffRational, 1, binary.LittleEndian.AppendUint32(value, den)}
+}
+
+// buildExif encodes a little endian TIFF block with the given IFD0 and EXIF sub-IFD entries,
+// which exif.Decode accepts just like the APP1 segment of a JPEG.
+func buildExif(t *testing.T, ifd0, sub []tiffEntry) []byte {
+	t.Helper()
+	ifdSize := func(entries []tiffEntry) int { return 2 + len(entries)*12 + 4 }
+
+	ifd0 = append([]tiffEntry(nil), ifd0...)
+	ifd0 = append(ifd0, tiffEntry{0x8769, tiffLong, 1, nil}) // ExifIFDPointer, filled in below
+	subOffset := 8 + ifdSize(ifd0)
+	ifd0[len(ifd0)-1].value = binary.LittleEndian.AppendUint32(nil, uint32(subOffset))
+	dataOffset := subOffset + ifdSize(sub)
+
+	var ifds, data bytes.Buffer
+	writeIFD := func(entries []tiffEntry) {
+		binary.Write(&ifds, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&ifds, binary.LittleEndian, e.tag)
+			binary.Write(&ifds, binary.LittleEndian, e.typ)
+			binary.Write(&ifds, binary.LittleEndian, e.count)
+			if len(e.value) <= 4 {
+				var inline [4]byte
+				copy(inline[:], e.value)
+				ifds.Write(inline[:])
+				continue
+			}
+			binary.Write(&ifds, binary.LittleEndian, uint32(dataOffset+data.Len()))
+			data.Write(e.value)
+			if data.Len()%2 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		binary.Write(&ifds, binary.LittleEndian, uint32(0))
+	}
+	writeIFD(ifd0)
+	writeIFD(sub)
+
+	var block bytes.Buffer
+	block.WriteString("II*\x00")
+	binary.Write(&block, binary.LittleEndian, uint32(8))
+	block.Write(ifds.Bytes())
+	block.Write(data.Bytes())
+	return block.Bytes()
+}
+
+// decodeExif decodes a block and runs the OffsetTime parser on it the way main registers it.
+func decodeExif(t *testing.T, block []byte) *exif.Exif {
+	t.Helper()
+	x, err := exif.Decode(bytes.NewReader(block))
+	if err != nil {
+		t.Fatalf("decode exif: %v", err)
+	}
+	if err := OffsetTimeParser.Parse(x); err != nil {
+		t.Fatalf("offset time parser: %v", err)
+	}
+	return x
+}
+
+func TestExtractExif(t *testing.T) {
+	block := buildExif(t,
+		[]tiffEntry{asciiEntry(0x010F, "Canon"), asciiEntry(0x0110, "EOS R6"), shortEntry(0x0112, 6)},
+		[]tiffEntry{
+			rationalEntry(0x829D, 28, 10),  // FNumber
+			rationalEntry(0x829A, 1, 250),  // ExposureTime
+			shortEntry(0x8827, 400),        // ISOSpeedRatings
+			rationalEntry(0x920A, 500, 10), // FocalLength
+			shortEntry(0x9209, 0x19),       // Flash: fired, auto mode
+			asciiEntry(0x9003, "2024:05:01 12:30:00"),
+		},
+	)
+
+	got, err := ExtractExif(block)
+	if err != nil {
+		t.Fatalf("ExtractExif: %v", err)
+	}
+	want := models.Exif{
+		Aperture:         "f/2.8",
+		ShutterSpeed:     "1/250",
+		ISO:              "400",
+		ImageOrientation: 6,
+		CameraMake:       "Canon",
+		CameraModel:      "EOS R6",
+		FocalLength:      50,
+		Flash:            "fired",
+	}
+	taken := got.DateTaken
+	got.DateTaken = nil
+	if got != want {
+		t.Errorf("ExtractExif() = %+v, want %+v", got, want)
+	}
+	if wantTaken := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC); taken == nil || !taken.Equal(wantTaken) {
+		t.Errorf("date taken = %v, want %v", taken, wantTaken)
+	}
+
+	if _, err := ExtractExif([]byte("not an image")); err == nil {
+		t.Error("expected an error for data without EXIF")
+	}
+}
+
+func TestExifDateTaken(t *testing.T) {
+	const (
+		dateTimeOriginal   = 0x9003
+		dateTime           = 0x0132
+		offsetTime         = 0x9010
+		offsetTimeOriginal = 0x9011
+	)
+	tests := []struct {
+		name       string
+		ifd0, sub  []tiffEntry
+		want       time.Time
+		wantOffset string
+	}{
+		{
+			name: "original with offset",
+			sub:  []tiffEntry{asciiEntry(dateTimeOriginal, "2024:05:01 12:30:00"), asciiEntry(offsetTimeOriginal, "+02:00")},
+			want: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), wantOffset: "+02:00",
+		},
+		{
+			name: "original without offset is kept as UTC",
+			sub:  []tiffEntry{asciiEntry(dateTimeOriginal, "2024:05:01 12:30:00")},
+			want: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "original ignores the offset of DateTime",
+			sub:  []tiffEntry{asciiEntry(dateTimeOriginal, "2024:05:01 12:30:00"), asciiEntry(offsetTime, "-05:00")},
+			want: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "falls back to DateTime and OffsetTime",
+			ifd0: []tiffEntry{asciiEntry(dateTime, "2023:12:31 23:00:00")},
+			sub:  []tiffEntry{asciiEntry(offsetTime, "-05:00"), asciiEntry(offsetTimeOriginal, "+09:00")},
+			want: time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC), wantOffset: "-05:00",
+		},
+		{
+			name: "invalid offset is dropped",
+			sub:  []tiffEntry{asciiEntry(dateTimeOriginal, "2024:05:01 12:30:00"), asciiEntry(offsetTimeOriginal, "CEST")},
+			want: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "no date",
+			sub:  []tiffEntry{asciiEntry(offsetTimeOriginal, "+02:00")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, offset := exifDateTaken(decodeExif(t, buildExif(t, tt.ifd0, tt.sub)))
+			if tt.want.IsZero() {
+				if got != nil || offset != "" {
+					t.Errorf("exifDateTaken() = %v, %q, want no date", got, offset)
+				}
+				return
+			}
+			if got == nil || !got.Equal(tt.want) || offset != tt.wantOffset {
+				t.Errorf("exifDateTaken() = %v, %q, want %v, %q", got, offset, tt.want, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestFormatShutterSpeed(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		want     string
+	}{
+		{1, 250, "1/250"},
+		{1, 8000, "1/8000"},
+		{10, 1250, "1/125"},
+		{2, 3, "1/2"},
+		{1, 1, "1s"},
+		{5, 2, "2.5s"},
+		{30, 1, "30s"},
+	}
+	for _, tt := range tests {
+		if got := formatShutterSpeed(big.NewRat(tt.num, tt.den)); got != tt.want {
+			t.Errorf("formatShutterSpeed(%d/%d) = %q, want %q", tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeFlash(t *testing.T) {
+	tests := []struct {
+		flash int
+		want  string
+	}{
+		{0x00, "not fired"},
+		{0x01, "fired"},
+		{0x10, "not fired"},
+		{0x19, "fired"},
+		{0x20, ""},
+		{0x21, ""},
+	}
+	for _, tt := range tests {
+		if got := describeFlash(tt.flash); got != tt.want {
+			t.Errorf("describeFlash(%#x) = %q, want %q", tt.flash, got, tt.want)
+		}
+	}
+}
+
+func TestMergeExif(t *testing.T) {
+	extracted := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	base := models.Exif{
+		Aperture:        "f/2.8",
+		ISO:             "400",
+		CameraMake:      "Canon",
+		FocalLength:     50,
+		Flash:           "fired",
+		DateTaken:       &extracted,
+		DateTakenOffset: "+02:00",
+		GPS:             &models.GPS{Latitude: 1, Longitude: 2},
+	}
+
+	if got := MergeExif(base, models.Exif{}); got != base {
+		t.Errorf("empty override changed the extracted values: %+v", got)
+	}
+
+	corrected := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	got := MergeExif(base, models.Exif{ISO: "800", DateTaken: &corrected})
+	if got.ISO != "800" {
+		t.Errorf("iso = %q, want 800", got.ISO)
+	}
+	if got.DateTaken != &corrected || got.DateTakenOffset != "" {
+		t.Errorf("date taken = %v %q, the override must replace the date and its offset", got.DateTaken, got.DateTakenOffset)
+	}
+	if got.Aperture != base.Aperture || got.CameraMake != base.CameraMake || got.FocalLength != base.FocalLength ||
+		got.Flash != base.Flash || got.GPS != base.GPS {
+		t.Errorf("fields left out of the override changed: %+v", got)
+	}
+}
@@ -3,83 +3,70 @@ package services
 
 import (
 	"bytes"
-	"errors"
 	"image"
 	"io"
 	"log"
+	"shutterdev/backend/internal/models"
 
 	"github.com/HugoSmits86/nativewebp"
-	"github.com/disintegration/imaging"
-	"github.com/nfnt/resize"
 )
 
-func ProcessImage(file io.Reader, imageOrientation int) (webImage []byte, thumbImage []byte, thumbWidth int, thumbHeight int, err error) {
+// ProcessedImage is everything ProcessImage derives from one upload.
+type ProcessedImage struct {
+	// WebImage is the uploaded file, re-encoded only when it had to be rotated or mirrored
+	WebImage    []byte
+	Renditions  []RenditionImage
+	Placeholder Placeholder
+	// Palette holds up to PaletteSize colours, the most common first
+	Palette []models.PaletteColor
+	// PerceptualHash is compared with HammingDistance to find near-duplicates
+	PerceptualHash uint64
+}
 
-	const MaxTotalPixelCount = 8000 * 8000 // Image width * Image height
-	var errThumb error = nil
+// ProcessImage turns the image upright according to its EXIF orientation and renders
+// every rendition in specs. The uploaded image is kept unchanged as WebImage unless it had to
+// be rotated or mirrored, see orientedWebImage. The caller checks the image against its
+// UploadPolicy first, ProcessImage decodes whatever it is given.
+func ProcessImage(file io.Reader, imageOrientation int, specs []RenditionSpec) (*ProcessedImage, error) {
 
 	// Copy the image byte stream into a bucket so that it can be reused
 	imageData, err := io.ReadAll(file)
 	if err != nil {
 		log.Println("[ERROR]: Could not dump Image Stream into Byte Slice", err)
-		return nil, nil, 0, 0, err
-	}
-
-	// <== Check Dimensions of Image & assign isPortrait value ==>
-	decodeConfigReader := bytes.NewReader(imageData)
-	imageConfig, _, err := image.DecodeConfig(decodeConfigReader)
-	if err != nil {
-		log.Println("[ERROR]: DecodeConfig failed to extract dimensions of the image")
-		return nil, nil, 0, 0, err
-	} else if (imageConfig.Width * imageConfig.Height) > MaxTotalPixelCount {
-		log.Println("[ERROR]: Image Dimensions are bigger than allowed dimensions")
-		ErrImageTooLarge := errors.New("Provided image exceeds the maximum dimensions")
-		return nil, nil, 0, 0, ErrImageTooLarge
+		return nil, err
 	}
 
 	// again create a new Reader for Resizing from the bucket (ImageData)
 	resizeImageReader := bytes.NewReader(imageData)
 
 	// Convert byte stream into image.Image object for manipulation
-	img, _, err := image.Decode(resizeImageReader)
+	img, format, err := image.Decode(resizeImageReader)
 	if err != nil {
 		log.Println("[ERROR]: Could not decode Image to image.Image", err)
-		return nil, nil, 0, 0, err
+		return nil, err
 	}
 
 	rotatedImg := applyOrientation(img, imageOrientation)
 
-	thumbImage, thumbWidth, thumbHeight, errThumb = resizeToThumb(rotatedImg)
-
-	if errThumb != nil {
-		log.Println("[ERROR]: Could not encode image.Image back to JPEG (for thumbImage)", errThumb)
-		return nil, nil, 0, 0, errThumb
-	}
-
-	return imageData, thumbImage, thumbWidth, thumbHeight, nil
-}
-
-func resizeToThumb(img image.Image) (finalThumbImage []byte, thumbWidth int, thumbHeight int, err error) {
-	var thumbResized image.Image
-	var sharpernedThumbResized image.Image
-	if img.Bounds().Dx() > img.Bounds().Dy() {
-		// landscape resize
-		thumbResized = resize.Resize(640, 0, img, resize.Lanczos3)
-		sharpernedThumbResized = imaging.Sharpen(thumbResized, 0.3)
-	} else {
-		// portrait resize
-		thumbResized = resize.Resize(0, 640, img, resize.Lanczos3)
-		sharpernedThumbResized = imaging.Sharpen(thumbResized, 0.3)
+	webImage, err := orientedWebImage(imageData, rotatedImg, format, imageOrientation)
+	if err != nil {
+		log.Println("[ERROR]: Could not re-encode the rotated web image", err)
+		return nil, err
 	}
-	thumbWidth = thumbResized.Bounds().Dx()
-	thumbHeight = thumbResized.Bounds().Dy()
 
-	finalThumbImage, err = encodeImageToWebP(sharpernedThumbResized)
+	renditions, err := renderRenditions(rotatedImg, specs)
 	if err != nil {
-		return nil, 0, 0, err
+		log.Println("[ERROR]: Could not render the renditions", err)
+		return nil, err
 	}
 
-	return finalThumbImage, thumbWidth, thumbHeight, nil
+	return &ProcessedImage{
+		WebImage:       webImage,
+		Renditions:     renditions,
+		Placeholder:    computePlaceholder(rotatedImg),
+		Palette:        extractPalette(rotatedImg),
+		PerceptualHash: perceptualHash(rotatedImg),
+	}, nil
 }
 
 // use https://github.com/HugoSmits86/nativewebp for encoding to WebP
@@ -93,16 +80,3 @@ func encodeImageToWebP(img image.Image) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
-
-func applyOrientation(img image.Image, orientation int) image.Image {
-	switch orientation {
-	case 3:
-		return imaging.Rotate180(img)
-	case 6:
-		return imaging.Rotate270(img)
-	case 8:
-		return imaging.Rotate90(img)
-	default:
-		return img
-	}
-}
@@ -0,0 +1,191 @@
+// store the processed images on the local disk so the backend can run without R2
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage writes objects below a root directory on disk.
+// The files are expected to be served by Gin under PublicBase.
+// It implements Storage.
+type LocalStorage struct {
+	RootDir    string
+	PublicBase string
+}
+
+// NewLocalStorage creates the root directory if needed and returns a disk backed Storage.
+func NewLocalStorage(rootDir, publicURL string) (*LocalStorage, error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("local storage directory is empty")
+	}
+
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local storage directory: %w", err)
+	}
+
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage directory: %w", err)
+	}
+
+	return &LocalStorage{
+		RootDir:    absRoot,
+		PublicBase: strings.TrimSuffix(publicURL, "/"),
+	}, nil
+}
+
+// UploadFile writes data to disk under key and returns its public URL.
+// The file is written to a temporary name first so readers never see a partial image.
+func (s *LocalStorage) UploadFile(ctx context.Context, fileName string, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	fullPath, err := s.pathFor(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", fileName, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file for %s: %w", fileName, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write file to local storage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write file to local storage: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", fmt.Errorf("failed to set permissions on %s: %w", fileName, err)
+	}
+
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return "", fmt.Errorf("failed to move file into local storage: %w", err)
+	}
+
+	return s.PublicURL(fileName), nil
+}
+
+// DeleteFile removes the file stored under key. Deleting a missing file is not an error.
+func (s *LocalStorage) DeleteFile(ctx context.Context, fileName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fullPath, err := s.pathFor(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file from local storage: %w", err)
+	}
+
+	log.Printf("[DELETE:SUCCESS]: Successfully deleted %v from local storage (%v)", fileName, s.RootDir)
+	return nil
+}
+
+// FileExists reports whether a regular file is stored under key.
+func (s *LocalStorage) FileExists(ctx context.Context, fileName string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	fullPath, err := s.pathFor(fileName)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check file in local storage: %w", err)
+	}
+
+	return info.Mode().IsRegular(), nil
+}
+
+// ListFiles walks RootDir and returns every stored file whose key starts with prefix.
+// Temporary files of uploads that are still being written are skipped.
+func (s *LocalStorage) ListFiles(ctx context.Context, prefix string) ([]StoredFile, error) {
+	var files []StoredFile
+
+	err := filepath.WalkDir(s.RootDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.RootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, StoredFile{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in local storage: %w", err)
+	}
+
+	return files, nil
+}
+
+// PublicURL returns the URL Gin serves the file stored under key from.
+func (s *LocalStorage) PublicURL(fileName string) string {
+	return fmt.Sprintf("%s/%s", s.PublicBase, fileName)
+}
+
+// pathFor maps a storage key to a path inside RootDir, rejecting keys that would escape it.
+func (s *LocalStorage) pathFor(fileName string) (string, error) {
+	if fileName == "" {
+		return "", ErrInvalidKey
+	}
+
+	cleaned := path.Clean("/" + fileName)
+	if cleaned == "/" {
+		return "", ErrInvalidKey
+	}
+
+	fullPath := filepath.Join(s.RootDir, filepath.FromSlash(cleaned))
+	if !strings.HasPrefix(fullPath, s.RootDir+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+
+	return fullPath, nil
+}
@@ -0,0 +1,157 @@
+// turn images upright according to their EXIF orientation, including the mirrored variants
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/disintegration/imaging"
+)
+
+// WebImageJPEGQuality is used when an uploaded JPEG has to be re-encoded to apply its orientation.
+const WebImageJPEGQuality = 95
+
+const exifOrientationTag = 0x0112
+
+// IsOrientationApplied reports whether applyOrientation changes images with this EXIF orientation.
+// Afterwards the pixels are upright and the orientation must be stored as 1.
+func IsOrientationApplied(orientation int) bool {
+	return orientation >= 2 && orientation <= 8
+}
+
+// applyOrientation turns img upright. The mirrored values (2, 4, 5 and 7) come from some phones
+// and front cameras.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// orientedWebImage returns the uploaded file when it is already upright. Otherwise the upright
+// image is re-encoded in the uploaded format. JPEGs keep their EXIF with the orientation reset
+// to 1, so browsers that honour the tag do not rotate the image a second time.
+func orientedWebImage(original []byte, upright image.Image, format string, orientation int) ([]byte, error) {
+	if !IsOrientationApplied(orientation) {
+		return original, nil
+	}
+
+	buf := new(bytes.Buffer)
+	switch format {
+	case "png":
+		if err := png.Encode(buf, upright); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "webp":
+		return encodeImageToWebP(upright)
+	default:
+		if err := jpeg.Encode(buf, upright, &jpeg.Options{Quality: WebImageJPEGQuality}); err != nil {
+			return nil, err
+		}
+		encoded := buf.Bytes()
+
+		segment := resetExifOrientation(jpegExifSegment(original))
+		if segment == nil {
+			return encoded, nil
+		}
+		// the encoder writes no metadata, so the Exif segment goes right after the SOI marker
+		withExif := make([]byte, 0, len(encoded)+len(segment))
+		withExif = append(withExif, encoded[:2]...)
+		withExif = append(withExif, segment...)
+		return append(withExif, encoded[2:]...), nil
+	}
+}
+
+// jpegExifSegment returns the APP1 Exif segment of a JPEG, marker and length included, or nil.
+func jpegExifSegment(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan or end of image, there is no metadata after this
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return data[i:end]
+		}
+		i = end
+	}
+	return nil
+}
+
+// resetExifOrientation returns a copy of an APP1 Exif segment with the orientation in IFD0 set to 1.
+// It returns nil when the segment cannot be parsed, the caller then drops the EXIF altogether.
+func resetExifOrientation(segment []byte) []byte {
+	// marker (2) + length (2) + "Exif\0\0" (6)
+	const tiffStart = 10
+	if len(segment) < tiffStart+8 {
+		return nil
+	}
+
+	patched := bytes.Clone(segment)
+	tiff := patched[tiffStart:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := range count {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return nil
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+
+		// type 3 is SHORT, a single SHORT is stored in the first two bytes of the value field
+		if order.Uint16(tiff[entry+2:]) != 3 {
+			return nil
+		}
+		order.PutUint16(tiff[entry+8:], 1)
+		return patched
+	}
+	return patched
+}
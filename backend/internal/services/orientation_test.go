@@ -0,0 +1,68 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// a 3x2 image where every pixel has its own colour, as the camera stored it
+	const w, h = 3, 2
+	stored := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			stored.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 100), G: uint8(y * 100), B: 50, A: 255})
+		}
+	}
+
+	// where the stored pixel (x, y) ends up once the image is upright, straight from the
+	// EXIF definition of each orientation (which side row 0 and column 0 are shown on)
+	uprightPosition := map[int]func(x, y int) (int, int){
+		1: func(x, y int) (int, int) { return x, y },
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return h - 1 - y, x },
+		7: func(x, y int) (int, int) { return h - 1 - y, w - 1 - x },
+		8: func(x, y int) (int, int) { return y, w - 1 - x },
+	}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		upright := applyOrientation(stored, orientation)
+
+		wantW, wantH := w, h
+		if orientation >= 5 {
+			wantW, wantH = h, w
+		}
+		bounds := upright.Bounds()
+		if bounds.Dx() != wantW || bounds.Dy() != wantH {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", orientation, bounds.Dx(), bounds.Dy(), wantW, wantH)
+			continue
+		}
+
+		for y := range h {
+			for x := range w {
+				ux, uy := uprightPosition[orientation](x, y)
+				got := color.NRGBAModel.Convert(upright.At(bounds.Min.X+ux, bounds.Min.Y+uy))
+				if want := stored.NRGBAAt(x, y); got != want {
+					t.Errorf("orientation %d: pixel (%d,%d) is %v at (%d,%d), want %v", orientation, x, y, got, ux, uy, want)
+				}
+			}
+		}
+
+		if want := orientation != 1; IsOrientationApplied(orientation) != want {
+			t.Errorf("IsOrientationApplied(%d) = %v, want %v", orientation, !want, want)
+		}
+	}
+
+	for _, orientation := range []int{0, 9, -1} {
+		if applyOrientation(stored, orientation) != image.Image(stored) {
+			t.Errorf("orientation %d changed the image", orientation)
+		}
+		if IsOrientationApplied(orientation) {
+			t.Errorf("IsOrientationApplied(%d) = true for an invalid orientation", orientation)
+		}
+	}
+}
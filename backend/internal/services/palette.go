@@ -0,0 +1,231 @@
+// extract a small weighted colour palette from an upload for the colour search
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"shutterdev/backend/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// PaletteSize is the most colours stored per photo.
+	PaletteSize = 5
+	// paletteMinWeight drops colours covering less of the image, they are specks rather than a mood.
+	paletteMinWeight = 0.03
+	// paletteSampleSize is the long edge the image is shrunk to before clustering.
+	paletteSampleSize = 64
+	paletteIterations = 10
+	// paletteSeedDistance keeps the starting centroids apart so one colour is not picked twice.
+	paletteSeedDistance = 10
+)
+
+type labPixel struct {
+	lab     [3]float64
+	r, g, b uint8
+}
+
+type paletteCluster struct {
+	center  [3]float64
+	count   int
+	r, g, b int
+	labSum  [3]float64
+}
+
+// extractPalette clusters the pixels with k-means in CIELAB, so the distances match how different
+// colours look, and returns the clusters as colours weighted by the share of the image they cover.
+// The centroids start at the most common colours, which keeps the result deterministic.
+func extractPalette(img image.Image) []models.PaletteColor {
+	sample := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+
+	pixels := make([]labPixel, 0, len(sample.Pix)/4)
+	for offset := 0; offset+3 < len(sample.Pix); offset += 4 {
+		if sample.Pix[offset+3] < 128 {
+			continue
+		}
+		r, g, b := sample.Pix[offset], sample.Pix[offset+1], sample.Pix[offset+2]
+		l, a, bb := RGBToLab(r, g, b)
+		pixels = append(pixels, labPixel{lab: [3]float64{l, a, bb}, r: r, g: g, b: b})
+	}
+	if len(pixels) == 0 {
+		return []models.PaletteColor{}
+	}
+
+	clusters := seedPaletteClusters(pixels)
+	for range paletteIterations {
+		for i := range clusters {
+			clusters[i].count, clusters[i].r, clusters[i].g, clusters[i].b = 0, 0, 0, 0
+			clusters[i].labSum = [3]float64{}
+		}
+
+		for _, pixel := range pixels {
+			nearest, nearestDistance := 0, math.Inf(1)
+			for j, cluster := range clusters {
+				if distance := labDistanceSquared(pixel.lab, cluster.center); distance < nearestDistance {
+					nearest, nearestDistance = j, distance
+				}
+			}
+
+			cluster := &clusters[nearest]
+			cluster.count++
+			cluster.r += int(pixel.r)
+			cluster.g += int(pixel.g)
+			cluster.b += int(pixel.b)
+			for k := range 3 {
+				cluster.labSum[k] += pixel.lab[k]
+			}
+		}
+
+		moved := false
+		for i := range clusters {
+			if clusters[i].count == 0 {
+				continue
+			}
+			var center [3]float64
+			for k := range 3 {
+				center[k] = clusters[i].labSum[k] / float64(clusters[i].count)
+			}
+			if labDistanceSquared(center, clusters[i].center) > 0.01 {
+				moved = true
+			}
+			clusters[i].center = center
+		}
+		if !moved {
+			break
+		}
+	}
+
+	palette := make([]models.PaletteColor, 0, len(clusters))
+	for _, cluster := range clusters {
+		weight := float64(cluster.count) / float64(len(pixels))
+		if cluster.count == 0 || weight < paletteMinWeight {
+			continue
+		}
+
+		r := uint8(cluster.r / cluster.count)
+		g := uint8(cluster.g / cluster.count)
+		b := uint8(cluster.b / cluster.count)
+		l, a, bb := RGBToLab(r, g, b)
+		palette = append(palette, models.PaletteColor{
+			Color:  fmt.Sprintf("#%02x%02x%02x", r, g, b),
+			Weight: math.Round(weight*1000) / 1000,
+			L:      l,
+			A:      a,
+			B:      bb,
+		})
+	}
+
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].Weight > palette[j].Weight
+	})
+	return palette
+}
+
+// seedPaletteClusters picks up to PaletteSize starting centroids from the fullest colour buckets
+// (three bits per channel), skipping buckets that look almost the same as one already picked.
+func seedPaletteClusters(pixels []labPixel) []paletteCluster {
+	type bucket struct {
+		count  int
+		labSum [3]float64
+	}
+	buckets := make(map[int]*bucket)
+	for _, pixel := range pixels {
+		key := int(pixel.r>>5)<<6 | int(pixel.g>>5)<<3 | int(pixel.b>>5)
+		entry := buckets[key]
+		if entry == nil {
+			entry = &bucket{}
+			buckets[key] = entry
+		}
+		entry.count++
+		for k := range 3 {
+			entry.labSum[k] += pixel.lab[k]
+		}
+	}
+
+	keys := make([]int, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if buckets[keys[i]].count != buckets[keys[j]].count {
+			return buckets[keys[i]].count > buckets[keys[j]].count
+		}
+		return keys[i] < keys[j]
+	})
+
+	var clusters []paletteCluster
+	for _, key := range keys {
+		entry := buckets[key]
+		var center [3]float64
+		for k := range 3 {
+			center[k] = entry.labSum[k] / float64(entry.count)
+		}
+
+		tooClose := false
+		for _, cluster := range clusters {
+			if labDistanceSquared(center, cluster.center) < paletteSeedDistance*paletteSeedDistance {
+				tooClose = true
+				break
+			}
+		}
+		if tooClose {
+			continue
+		}
+
+		clusters = append(clusters, paletteCluster{center: center})
+		if len(clusters) == PaletteSize {
+			break
+		}
+	}
+	return clusters
+}
+
+// RGBToLab converts an sRGB colour to CIELAB (D65 white point). The euclidean distance between two
+// Lab colours (delta E 1976) roughly matches how different they look: below 2 is hard to tell apart.
+func RGBToLab(r, g, b uint8) (l, a, bb float64) {
+	rl, gl, bl := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bl) / 0.95047
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bl) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func labF(t float64) float64 {
+	const epsilon = 216.0 / 24389.0
+	const kappa = 24389.0 / 27.0
+	if t > epsilon {
+		return math.Cbrt(t)
+	}
+	return (kappa*t + 16) / 116
+}
+
+func labDistanceSquared(x, y [3]float64) float64 {
+	dl, da, db := x[0]-y[0], x[1]-y[1], x[2]-y[2]
+	return dl*dl + da*da + db*db
+}
+
+// ParseHexColor reads "#rrggbb", "rrggbb" or the short "#rgb" form.
+func ParseHexColor(value string) (r, g, b uint8, err error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("colour %q must look like #rrggbb", value)
+	}
+
+	parsed, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("colour %q must look like #rrggbb", value)
+	}
+	return uint8(parsed >> 16), uint8(parsed >> 8), uint8(parsed), nil
+}
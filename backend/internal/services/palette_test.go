@@ -0,0 +1,93 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// fillRect paints the rectangle of img with c.
+func fillRect(img *image.NRGBA, rect image.Rectangle, c color.NRGBA) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+var (
+	paletteRed   = color.NRGBA{R: 200, G: 30, B: 30, A: 255}
+	paletteBlue  = color.NRGBA{R: 20, G: 40, B: 180, A: 255}
+	paletteGreen = color.NRGBA{R: 30, G: 200, B: 40, A: 255}
+)
+
+func TestExtractPaletteTwoColours(t *testing.T) {
+	// no larger than the sample size, so no resampling blends the two halves
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	fillRect(img, image.Rect(0, 0, 48, 32), paletteRed)
+	fillRect(img, image.Rect(48, 0, 64, 32), paletteBlue)
+
+	palette := extractPalette(img)
+	if len(palette) != 2 {
+		t.Fatalf("palette = %+v, want 2 colours", palette)
+	}
+	if palette[0].Color != "#c81e1e" || palette[1].Color != "#1428b4" {
+		t.Errorf("colours = %s, %s, want #c81e1e then #1428b4", palette[0].Color, palette[1].Color)
+	}
+	if palette[0].Weight != 0.75 || palette[1].Weight != 0.25 {
+		t.Errorf("weights = %v, %v, want 0.75 and 0.25", palette[0].Weight, palette[1].Weight)
+	}
+	if sum := palette[0].Weight + palette[1].Weight; math.Abs(sum-1) > 0.01 {
+		t.Errorf("weights sum to %v, want about 1", sum)
+	}
+
+	l, a, b := RGBToLab(paletteRed.R, paletteRed.G, paletteRed.B)
+	if palette[0].L != l || palette[0].A != a || palette[0].B != b {
+		t.Errorf("lab = %v %v %v, want %v %v %v", palette[0].L, palette[0].A, palette[0].B, l, a, b)
+	}
+}
+
+func TestExtractPaletteDropsSpecks(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	fillRect(img, img.Rect, paletteRed)
+	// 16 of 4096 pixels, well under paletteMinWeight
+	fillRect(img, image.Rect(10, 10, 14, 14), paletteGreen)
+
+	palette := extractPalette(img)
+	if len(palette) != 1 || palette[0].Color != "#c81e1e" {
+		t.Errorf("palette = %+v, want only the red", palette)
+	}
+}
+
+func TestExtractPaletteTransparentImage(t *testing.T) {
+	palette := extractPalette(image.NewNRGBA(image.Rect(0, 0, 32, 32)))
+	if palette == nil || len(palette) != 0 {
+		t.Errorf("palette = %#v, want an empty palette", palette)
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		value   string
+		r, g, b uint8
+		wantErr bool
+	}{
+		{value: "#abc", r: 0xaa, g: 0xbb, b: 0xcc},
+		{value: "abc123", r: 0xab, g: 0xc1, b: 0x23},
+		{value: " #FF8000 ", r: 0xff, g: 0x80, b: 0x00},
+		{value: "#abcd", wantErr: true},
+		{value: "#ggg", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, g, b, err := ParseHexColor(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHexColor(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (r != tt.r || g != tt.g || b != tt.b) {
+			t.Errorf("ParseHexColor(%q) = %d %d %d, want %d %d %d", tt.value, r, g, b, tt.r, tt.g, tt.b)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// perceptual hash used to find duplicate and similar photos
+package services
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// perceptualHash is a 64 bit difference hash (dHash): the image is shrunk to 9x8 grey pixels and
+// every bit says whether a pixel is brighter than its right neighbour. Re-encodes, resizes and small
+// edits flip only a few bits, so the Hamming distance between two hashes measures how alike they look.
+func perceptualHash(img image.Image) uint64 {
+	small := imaging.Resize(imaging.Grayscale(img), 9, 8, imaging.Box)
+
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			left := small.Pix[y*small.Stride+x*4]
+			right := small.Pix[y*small.Stride+(x+1)*4]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance counts the bits two perceptual hashes differ in, 0 (identical) to 64.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
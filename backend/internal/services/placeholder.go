@@ -0,0 +1,182 @@
+// compute the BlurHash and dominant colour the frontend shows while a thumbnail is loading
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// placeholderSampleSize is the long edge the image is shrunk to before hashing. A BlurHash keeps
+// only 4x3 components, so 32 pixels are plenty and keep the cost per upload negligible.
+const placeholderSampleSize = 32
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Placeholder is what the feed can paint before the thumbnail arrives.
+type Placeholder struct {
+	// BlurHash, see https://blurha.sh. 4x3 components for landscape images, 3x4 for portrait.
+	BlurHash string
+	// DominantColor is the most common colour as #rrggbb.
+	DominantColor string
+}
+
+func computePlaceholder(img image.Image) Placeholder {
+	sample := imaging.Fit(img, placeholderSampleSize, placeholderSampleSize, imaging.Box)
+
+	componentsX, componentsY := 4, 3
+	if sample.Bounds().Dy() > sample.Bounds().Dx() {
+		componentsX, componentsY = 3, 4
+	}
+
+	return Placeholder{
+		BlurHash:      encodeBlurHash(sample, componentsX, componentsY),
+		DominantColor: dominantColor(sample),
+	}
+}
+
+// encodeBlurHash follows the reference implementation at https://github.com/woltapp/blurhash.
+func encodeBlurHash(img *image.NRGBA, componentsX, componentsY int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// convert every pixel to linear RGB once, the basis functions below read them componentsX*componentsY times
+	linear := make([][3]float64, width*height)
+	for y := range height {
+		for x := range width {
+			offset := y*img.Stride + x*4
+			linear[y*width+x] = [3]float64{
+				srgbToLinear(img.Pix[offset]),
+				srgbToLinear(img.Pix[offset+1]),
+				srgbToLinear(img.Pix[offset+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := range componentsY {
+		for i := range componentsX {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := range height {
+				for x := range width {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	dc, ac := factors[0], factors[1:]
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			actualMaximum = max(actualMaximum, math.Abs(factor[0]), math.Abs(factor[1]), math.Abs(factor[2]))
+		}
+		quantisedMaximum := int(max(0, min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dcValue := linearToSRGB(dc[0])<<16 + linearToSRGB(dc[1])<<8 + linearToSRGB(dc[2])
+	hash.WriteString(encodeBase83(dcValue, 4))
+
+	for _, factor := range ac {
+		quantise := func(value float64) int {
+			return int(max(0, min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2))
+	}
+
+	return hash.String()
+}
+
+// dominantColor buckets pixels by their top four bits per channel and averages the fullest bucket.
+// Transparent pixels are skipped, a fully transparent image is reported as black.
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+
+	var fullest *bucket
+	for offset := 0; offset+3 < len(img.Pix); offset += 4 {
+		if img.Pix[offset+3] < 128 {
+			continue
+		}
+		r, g, b := int(img.Pix[offset]), int(img.Pix[offset+1]), int(img.Pix[offset+2])
+
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		entry := buckets[key]
+		if entry == nil {
+			entry = &bucket{}
+			buckets[key] = entry
+		}
+		entry.count++
+		entry.r += r
+		entry.g += g
+		entry.b += b
+
+		if fullest == nil || entry.count > fullest.count {
+			fullest = entry
+		}
+	}
+
+	if fullest == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", fullest.r/fullest.count, fullest.g/fullest.count, fullest.b/fullest.count)
+}
+
+func encodeBase83(value, length int) string {
+	encoded := make([]byte, length)
+	for i := range length {
+		digit := value
+		for range length - i - 1 {
+			digit /= 83
+		}
+		encoded[i] = base83Chars[digit%83]
+	}
+	return string(encoded)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(math.Round(v * 12.92 * 255))
+	}
+	return int(math.Round((1.055*math.Pow(v, 1/2.4) - 0.055) * 255))
+}
+
+func signPow(value, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeBlurHash(t *testing.T) {
+	// the expected hashes were computed with a direct port of the reference encoder
+	// (https://github.com/woltapp/blurhash, C/encode.c) on the same pixels
+	tests := []struct {
+		name                     string
+		width, height            int
+		componentsX, componentsY int
+		pixel                    func(x, y int) color.NRGBA
+		want                     string
+	}{
+		{"solid colour", 8, 6, 4, 3, func(x, y int) color.NRGBA {
+			return color.NRGBA{200, 100, 50, 255}
+		}, "LVM|T9^4fQ^4}XsofQsofQfQfQfQ"},
+		{"gradient", 32, 24, 4, 3, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 255 / 31), uint8(y * 255 / 23), 128, 255}
+		}, "L$HewF2swxX8l}WDjte;gJfjfQfj"},
+		{"portrait split", 24, 32, 3, 4, func(x, y int) color.NRGBA {
+			if x < 12 {
+				return color.NRGBA{255, 0, 0, 255}
+			}
+			return color.NRGBA{0, 0, 255, 255}
+		}, "T~LjfL|Twto3n~jsfQfQfQo3n~js"},
+		{"checkerboard", 16, 12, 4, 3, func(x, y int) color.NRGBA {
+			if (x/4+y/4)%2 == 0 {
+				return color.NRGBA{255, 255, 255, 255}
+			}
+			return color.NRGBA{0, 0, 0, 255}
+		}, "LhLqe9?bfQ_3%Mj[fQj[fQ?bfQ~q"},
+		{"dc only", 4, 4, 1, 1, func(x, y int) color.NRGBA {
+			return color.NRGBA{255, 255, 255, 255}
+		}, "00TSUA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := range tt.height {
+				for x := range tt.width {
+					img.SetNRGBA(x, y, tt.pixel(x, y))
+				}
+			}
+			if got := encodeBlurHash(img, tt.componentsX, tt.componentsY); got != tt.want {
+				t.Errorf("encodeBlurHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// bound how many images are decoded and resized at once, and how much memory they may take
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrPoolSaturated is returned by ProcessingPool.Acquire when the queue is full or the wait
+// timed out. Callers should answer 503 with a Retry-After header.
+var ErrPoolSaturated = errors.New("image processing is saturated, try again later")
+
+// latencySamples is how many recent waits and runs the percentiles are computed over.
+const latencySamples = 256
+
+// PoolConfig sizes a ProcessingPool.
+type PoolConfig struct {
+	// Concurrency is how many images are processed at the same time
+	Concurrency int
+	// MemoryBudget is the estimated bytes all running jobs may hold together
+	MemoryBudget int64
+	// QueueSize is how many jobs may wait for a slot, further jobs are rejected right away
+	QueueSize int
+	// QueueTimeout is the longest a job waits for a slot before it is rejected
+	QueueTimeout time.Duration
+}
+
+// ProcessingPool admits jobs first come, first served while both a concurrency slot and enough of
+// the memory budget are free. A job larger than the whole budget runs once nothing else does.
+type ProcessingPool struct {
+	config PoolConfig
+
+	mu       sync.Mutex
+	running  int
+	memory   int64
+	waiting  []*poolWaiter
+	waits    latencyWindow
+	runs     latencyWindow
+	finished int64
+	rejected int64
+}
+
+type poolWaiter struct {
+	cost     int64
+	admitted chan struct{}
+}
+
+// PoolStats is a snapshot of a ProcessingPool for the admin endpoint.
+type PoolStats struct {
+	Concurrency   int     `json:"concurrency"`
+	MemoryBudget  int64   `json:"memoryBudget"`
+	QueueSize     int     `json:"queueSize"`
+	Running       int     `json:"running"`
+	Queued        int     `json:"queued"`
+	MemoryInUse   int64   `json:"memoryInUse"`
+	Finished      int64   `json:"finished"`
+	Rejected      int64   `json:"rejected"`
+	WaitP50Ms     float64 `json:"waitP50Ms"`
+	WaitP95Ms     float64 `json:"waitP95Ms"`
+	ProcessP50Ms  float64 `json:"processP50Ms"`
+	ProcessP95Ms  float64 `json:"processP95Ms"`
+	ProcessMeanMs float64 `json:"processMeanMs"`
+}
+
+// DefaultPoolConfig processes one image per CPU within 512 MB, with room for a batch of 16 to wait.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		Concurrency:  runtime.NumCPU(),
+		MemoryBudget: 512 << 20,
+		QueueSize:    16,
+		QueueTimeout: time.Minute,
+	}
+}
+
+func NewProcessingPool(config PoolConfig) *ProcessingPool {
+	config.Concurrency = max(config.Concurrency, 1)
+	config.QueueSize = max(config.QueueSize, 0)
+	return &ProcessingPool{config: config}
+}
+
+// EstimateProcessingCost guesses the peak memory of processing one upload: the file itself and
+// the web image copy, plus the decoded pixels and the upright copy at 4 bytes per pixel each.
+func EstimateProcessingCost(width, height int, fileSize int64) int64 {
+	return 2*fileSize + 8*int64(width)*int64(height)
+}
+
+// Acquire waits for a slot with room for cost bytes. The returned release must be called once the
+// job's memory is freed. It fails with ErrPoolSaturated, or the context error if ctx ends first.
+func (p *ProcessingPool) Acquire(ctx context.Context, cost int64) (release func(), err error) {
+	queuedAt := time.Now()
+
+	p.mu.Lock()
+	if len(p.waiting) == 0 && p.fits(cost) {
+		p.admit(cost)
+		p.waits.add(0)
+		p.mu.Unlock()
+		return p.releaseFunc(cost), nil
+	}
+	if len(p.waiting) >= p.config.QueueSize {
+		p.rejected++
+		p.mu.Unlock()
+		return nil, ErrPoolSaturated
+	}
+	waiter := &poolWaiter{cost: cost, admitted: make(chan struct{})}
+	p.waiting = append(p.waiting, waiter)
+	p.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if p.config.QueueTimeout > 0 {
+		timer := time.NewTimer(p.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-waiter.admitted:
+		p.mu.Lock()
+		p.waits.add(time.Since(queuedAt))
+		p.mu.Unlock()
+		return p.releaseFunc(cost), nil
+	case <-timeout:
+		err = ErrPoolSaturated
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-waiter.admitted:
+		// admitted while giving up, hand the slot to the next job
+		p.memory -= waiter.cost
+		p.running--
+		p.admitWaiting()
+	default:
+		p.waiting = slices.DeleteFunc(p.waiting, func(w *poolWaiter) bool { return w == waiter })
+		// the jobs behind it may fit now
+		p.admitWaiting()
+	}
+	if errors.Is(err, ErrPoolSaturated) {
+		p.rejected++
+	}
+	return nil, err
+}
+
+// RetryAfter estimates how long a rejected client should wait: the queue ahead of it divided over
+// the slots, times the typical processing time. Never less than a second.
+func (p *ProcessingPool) RetryAfter() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	typical := p.runs.percentile(0.5)
+	if typical == 0 {
+		typical = time.Second
+	}
+	rounds := float64(len(p.waiting)+p.running) / float64(p.config.Concurrency)
+	return max(time.Second, time.Duration(math.Ceil(rounds))*typical)
+}
+
+// Concurrency is how many jobs run at the same time.
+func (p *ProcessingPool) Concurrency() int {
+	return p.config.Concurrency
+}
+
+func (p *ProcessingPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PoolStats{
+		Concurrency:   p.config.Concurrency,
+		MemoryBudget:  p.config.MemoryBudget,
+		QueueSize:     p.config.QueueSize,
+		Running:       p.running,
+		Queued:        len(p.waiting),
+		MemoryInUse:   p.memory,
+		Finished:      p.finished,
+		Rejected:      p.rejected,
+		WaitP50Ms:     milliseconds(p.waits.percentile(0.5)),
+		WaitP95Ms:     milliseconds(p.waits.percentile(0.95)),
+		ProcessP50Ms:  milliseconds(p.runs.percentile(0.5)),
+		ProcessP95Ms:  milliseconds(p.runs.percentile(0.95)),
+		ProcessMeanMs: milliseconds(p.runs.mean()),
+	}
+}
+
+// fits reports whether a job of cost can start now. p.mu must be held.
+func (p *ProcessingPool) fits(cost int64) bool {
+	if p.running >= p.config.Concurrency {
+		return false
+	}
+	if p.config.MemoryBudget <= 0 || p.running == 0 {
+		return true
+	}
+	return p.memory+cost <= p.config.MemoryBudget
+}
+
+// admit books a slot. p.mu must be held.
+func (p *ProcessingPool) admit(cost int64) {
+	p.running++
+	p.memory += cost
+}
+
+// admitWaiting starts queued jobs in order until the head of the queue does not fit. p.mu must be held.
+func (p *ProcessingPool) admitWaiting() {
+	for len(p.waiting) > 0 && p.fits(p.waiting[0].cost) {
+		waiter := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.admit(waiter.cost)
+		close(waiter.admitted)
+	}
+}
+
+func (p *ProcessingPool) releaseFunc(cost int64) func() {
+	startedAt := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.running--
+			p.memory -= cost
+			p.finished++
+			p.runs.add(time.Since(startedAt))
+			p.admitWaiting()
+		})
+	}
+}
+
+// latencyWindow keeps the most recent latencySamples durations.
+type latencyWindow struct {
+	samples [latencySamples]time.Duration
+	count   int
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+	w.count = min(w.count+1, latencySamples)
+}
+
+func (w *latencyWindow) percentile(q float64) time.Duration {
+	if w.count == 0 {
+		return 0
+	}
+	sorted := slices.Clone(w.samples[:w.count])
+	slices.Sort(sorted)
+	return sorted[int(math.Ceil(q*float64(w.count)))-1]
+}
+
+func (w *latencyWindow) mean() time.Duration {
+	if w.count == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, sample := range w.samples[:w.count] {
+		total += sample
+	}
+	return total / time.Duration(w.count)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
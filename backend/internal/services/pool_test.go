@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync starts Acquire in the background, the channel receives its release or error.
+func acquireAsync(p *ProcessingPool, ctx context.Context, cost int64) <-chan acquireResult {
+	result := make(chan acquireResult, 1)
+	go func() {
+		release, err := p.Acquire(ctx, cost)
+		result <- acquireResult{release, err}
+	}()
+	return result
+}
+
+type acquireResult struct {
+	release func()
+	err     error
+}
+
+func waitQueued(t *testing.T, p *ProcessingPool, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Queued != queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length is %d, want %d", p.Stats().Queued, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func expectAdmitted(t *testing.T, result <-chan acquireResult) func() {
+	t.Helper()
+	select {
+	case r := <-result:
+		if r.err != nil {
+			t.Fatalf("Acquire failed: %v", r.err)
+		}
+		return r.release
+	case <-time.After(time.Second):
+		t.Fatal("Acquire was not admitted")
+		return nil
+	}
+}
+
+func expectWaiting(t *testing.T, result <-chan acquireResult) {
+	t.Helper()
+	select {
+	case r := <-result:
+		t.Fatalf("Acquire returned (err %v) while it should wait", r.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestProcessingPoolConcurrency(t *testing.T) {
+	p := NewProcessingPool(PoolConfig{Concurrency: 2, QueueSize: 4, QueueTimeout: time.Minute})
+	ctx := context.Background()
+
+	first := expectAdmitted(t, acquireAsync(p, ctx, 1))
+	second := expectAdmitted(t, acquireAsync(p, ctx, 1))
+	third := acquireAsync(p, ctx, 1)
+	expectWaiting(t, third)
+
+	first()
+	first() // releasing twice must not free a second slot
+	release := expectAdmitted(t, third)
+
+	fourth := acquireAsync(p, ctx, 1)
+	expectWaiting(t, fourth)
+	second()
+	expectAdmitted(t, fourth)()
+	release()
+
+	if stats := p.Stats(); stats.Running != 0 || stats.Finished != 4 || stats.MemoryInUse != 0 {
+		t.Errorf("stats after all releases = %+v", stats)
+	}
+}
+
+func TestProcessingPoolMemoryBudget(t *testing.T) {
+	p := NewProcessingPool(PoolConfig{Concurrency: 4, MemoryBudget: 100, QueueSize: 4, QueueTimeout: time.Minute})
+	ctx := context.Background()
+
+	large := expectAdmitted(t, acquireAsync(p, ctx, 60))
+	overBudget := acquireAsync(p, ctx, 60)
+	expectWaiting(t, overBudget)
+	waitQueued(t, p, 1)
+
+	// first come, first served: a small job that would fit does not overtake the queue
+	small := acquireAsync(p, ctx, 10)
+	expectWaiting(t, small)
+
+	large()
+	expectAdmitted(t, overBudget)()
+	expectAdmitted(t, small)()
+
+	// a job larger than the whole budget still runs once nothing else does
+	expectAdmitted(t, acquireAsync(p, ctx, 1000))()
+}
+
+func TestProcessingPoolRejects(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("queue full", func(t *testing.T) {
+		p := NewProcessingPool(PoolConfig{Concurrency: 1, QueueSize: 0, QueueTimeout: time.Minute})
+		release := expectAdmitted(t, acquireAsync(p, ctx, 1))
+		defer release()
+
+		if _, err := p.Acquire(ctx, 1); !errors.Is(err, ErrPoolSaturated) {
+			t.Fatalf("err = %v, want ErrPoolSaturated", err)
+		}
+		if stats := p.Stats(); stats.Rejected != 1 {
+			t.Errorf("rejected = %d, want 1", stats.Rejected)
+		}
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		p := NewProcessingPool(PoolConfig{Concurrency: 1, QueueSize: 1, QueueTimeout: 20 * time.Millisecond})
+		release := expectAdmitted(t, acquireAsync(p, ctx, 1))
+		defer release()
+
+		if _, err := p.Acquire(ctx, 1); !errors.Is(err, ErrPoolSaturated) {
+			t.Fatalf("err = %v, want ErrPoolSaturated", err)
+		}
+		if stats := p.Stats(); stats.Queued != 0 || stats.Rejected != 1 {
+			t.Errorf("stats after the timeout = %+v", stats)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		p := NewProcessingPool(PoolConfig{Concurrency: 1, QueueSize: 1, QueueTimeout: time.Minute})
+		release := expectAdmitted(t, acquireAsync(p, ctx, 1))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		waiting := acquireAsync(p, cancelled, 1)
+		waitQueued(t, p, 1)
+		cancel()
+		select {
+		case r := <-waiting:
+			if !errors.Is(r.err, context.Canceled) {
+				t.Fatalf("err = %v, want context.Canceled", r.err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Acquire did not return after the context was cancelled")
+		}
+
+		// the cancelled job left the queue, the slot goes to the next one
+		release()
+		expectAdmitted(t, acquireAsync(p, ctx, 1))()
+		if stats := p.Stats(); stats.Rejected != 0 || stats.Running != 0 {
+			t.Errorf("stats = %+v", stats)
+		}
+	})
+}
@@ -1,58 +1,118 @@
-// upload both the resized images to the R2 bucket and return the public URL
+// upload both the resized images to the R2 (or any S3-compatible) bucket and return the public URL
 package services
 
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
-// R2Service holds the configured S3 client and bucket info for R2.
+// R2Service holds the configured S3 client and bucket info for R2
+// or any other S3-compatible provider. It implements Storage.
 type R2Service struct {
 	Client     *s3.Client
 	BucketName string
-	PublicURL  string
+	PublicBase string
+}
+
+// S3Config describes any S3-compatible endpoint (R2, MinIO, Garage, Backblaze B2, AWS).
+type S3Config struct {
+	// Endpoint is the base URL of the S3 API, e.g. http://localhost:9000.
+	// Leave empty to use the default AWS endpoint for Region.
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	PublicURL string
+	// UsePathStyle addresses objects as <endpoint>/<bucket>/<key> instead of <bucket>.<endpoint>/<key>.
+	// MinIO, Garage and SeaweedFS usually need this.
+	UsePathStyle bool
+	// InsecureSkipVerify disables TLS certificate checks, only meant for self-signed local setups.
+	InsecureSkipVerify bool
 }
 
 // NewR2Service creates and configures a new R2 client.
 // It takes the configuration values from the environment.
 func NewR2Service(accountID, accessKey, secretKey, publicURL, bucketName string) (*R2Service, error) {
+	return NewS3Service(S3Config{
+		Endpoint:  fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID),
+		Region:    "auto",
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Bucket:    bucketName,
+		PublicURL: publicURL,
+	})
+}
 
-	// 1. Create the R2 endpoint URL
-	r2Endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID)
+// NewS3Service creates a client for an arbitrary S3-compatible endpoint.
+func NewS3Service(s3Cfg S3Config) (*R2Service, error) {
+
+	if s3Cfg.Bucket == "" {
+		return nil, fmt.Errorf("bucket name is empty")
+	}
+
+	region := s3Cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
 
-	// 2. Create the static credentials provider
-	creds := credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")
+	// 1. Create the static credentials provider
+	creds := credentials.NewStaticCredentialsProvider(s3Cfg.AccessKey, s3Cfg.SecretKey, "")
 
-	// 3. Load the default AWS configuration, and then override it.
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	// 2. Load the default AWS configuration, and then override it.
+	// Checksums are only sent when required since most non-AWS providers reject the newer defaults.
+	loadOptions := []func(*config.LoadOptions) error{
 		config.WithCredentialsProvider(creds),
-		config.WithRegion("auto"),
-	)
+		config.WithRegion(region),
+		config.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+		config.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
+	}
+
+	if s3Cfg.InsecureSkipVerify {
+		log.Println("[STORAGE] TLS certificate verification is disabled for the S3 endpoint")
+		httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})
+		loadOptions = append(loadOptions, config.WithHTTPClient(httpClient))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), loadOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// 4. Create the S3 client, using the non-deprecated method
+	// 3. Create the S3 client, using the non-deprecated method
 	// of setting the BaseEndpoint in the options.
 	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(r2Endpoint)
+		if s3Cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(strings.TrimSuffix(s3Cfg.Endpoint, "/"))
+		}
+		o.UsePathStyle = s3Cfg.UsePathStyle
 	})
 
-	// 5. Return our new service struct
+	// 4. Return our new service struct
 	return &R2Service{
 		Client:     s3Client,
-		BucketName: bucketName,
-		PublicURL:  publicURL,
+		BucketName: s3Cfg.Bucket,
+		PublicBase: strings.TrimSuffix(s3Cfg.PublicURL, "/"),
 	}, nil
 }
 
@@ -69,11 +129,15 @@ func (s *R2Service) UploadFile(ctx context.Context, fileName string, data []byte
 
 	_, err := s.Client.PutObject(ctx, input)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file to R2: %w", err)
+		return "", fmt.Errorf("failed to upload file to bucket: %w", err)
 	}
 
-	publicURL := fmt.Sprintf("%s/%s", s.PublicURL, fileName)
-	return publicURL, nil
+	return s.PublicURL(fileName), nil
+}
+
+// PublicURL returns the public bucket URL for the given key.
+func (s *R2Service) PublicURL(fileName string) string {
+	return fmt.Sprintf("%s/%s", s.PublicBase, fileName)
 }
 
 // DeleteFile removes a file from the R2 bucket.
@@ -86,13 +150,59 @@ func (s *R2Service) DeleteFile(ctx context.Context, fileName string) error {
 
 	_, err := s.Client.DeleteObject(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to delete file from R2: %w", err)
+		return fmt.Errorf("failed to delete file from bucket: %w", err)
 	}
 
 	log.Printf("[DELETE:SUCCESS]: Successfully deleted %v from R2 Bucket (%v)", fileName, s.BucketName)
 	return nil
 }
 
+// FileExists checks whether an object with the given key is present in the R2 bucket.
+func (s *R2Service) FileExists(ctx context.Context, fileName string) (bool, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(fileName),
+	}
+
+	_, err := s.Client.HeadObject(ctx, input)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check file in bucket: %w", err)
+	}
+
+	return true, nil
+}
+
+// ListFiles pages through the bucket and returns every object under prefix.
+func (s *R2Service) ListFiles(ctx context.Context, prefix string) ([]StoredFile, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.BucketName),
+		Prefix: aws.String(prefix),
+	}
+
+	var files []StoredFile
+	paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files in bucket: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			files = append(files, StoredFile{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+
+	return files, nil
+}
+
 // GenerateUniqueFileName creates a unique name while preserving the file extension.
 // This function is perfect, no changes needed.
 func GenerateUniqueFileName(basePath string) string {
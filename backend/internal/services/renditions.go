@@ -0,0 +1,314 @@
+// resize the uploaded image into the configured set of renditions used for srcset
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/nfnt/resize"
+)
+
+const (
+	FormatWebP = "webp"
+	FormatJPEG = "jpeg"
+	FormatAVIF = "avif"
+)
+
+// FallbackJPEGQuality is used for the JPEG copies added by WithFallbacks.
+const FallbackJPEGQuality = 85
+
+// Encoder turns a rendered image into file bytes. quality is 1-100, 0 means the encoder default.
+type Encoder func(img image.Image, quality int) ([]byte, error)
+
+var encoders = map[string]Encoder{
+	FormatWebP: func(img image.Image, _ int) ([]byte, error) { return encodeImageToWebP(img) },
+	FormatJPEG: encodeJPEG,
+}
+
+// RegisterEncoder adds or replaces the encoder of a format. No pure-Go AVIF encoder ships with
+// the server, a build that has one registers it for FormatAVIF from an init function.
+func RegisterEncoder(format string, encoder Encoder) {
+	encoders[format] = encoder
+}
+
+// HasEncoder reports whether renditions can be rendered in format.
+func HasEncoder(format string) bool {
+	return encoders[format] != nil
+}
+
+// FormatMIMEType returns the Content-Type of a rendition format.
+func FormatMIMEType(format string) string {
+	return "image/" + format
+}
+
+// ThumbnailLongEdge is the size the feed thumbnail has always been rendered at.
+const ThumbnailLongEdge = 640
+
+// RenditionSpec is one configured output size. LongEdge is the size of the longer side in pixels.
+// Quality (1-100) applies to JPEG and AVIF, WebP renditions are always lossless.
+type RenditionSpec struct {
+	LongEdge int
+	Format   string
+	Quality  int
+}
+
+// RenditionImage is an encoded rendition ready to be uploaded.
+type RenditionImage struct {
+	Spec   RenditionSpec
+	Data   []byte
+	Width  int
+	Height int
+}
+
+var DefaultRenditions = []RenditionSpec{
+	{LongEdge: 320, Format: FormatWebP},
+	{LongEdge: 640, Format: FormatWebP},
+	{LongEdge: 1280, Format: FormatWebP},
+	{LongEdge: 2048, Format: FormatJPEG, Quality: 85},
+}
+
+// ParseRenditionSpecs reads a comma separated list of <longEdge>:<format>[:<quality>],
+// e.g. "320:webp,640:webp,1280:jpeg:82". An empty string returns DefaultRenditions.
+func ParseRenditionSpecs(value string) ([]RenditionSpec, error) {
+	if strings.TrimSpace(value) == "" {
+		return DefaultRenditions, nil
+	}
+
+	var specs []RenditionSpec
+	seen := make(map[string]bool)
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("rendition %q must look like <longEdge>:<format>[:<quality>]", part)
+		}
+
+		longEdge, err := strconv.Atoi(fields[0])
+		if err != nil || longEdge < 16 || longEdge > 8000 {
+			return nil, fmt.Errorf("rendition %q has an invalid size, use 16-8000 pixels", part)
+		}
+
+		format, err := ParseFormat(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("rendition %q: %w", part, err)
+		}
+
+		spec := RenditionSpec{LongEdge: longEdge, Format: format}
+		if len(fields) == 3 {
+			if format == FormatWebP {
+				return nil, fmt.Errorf("rendition %q sets a quality, but WebP renditions are always lossless", part)
+			}
+			quality, err := strconv.Atoi(fields[2])
+			if err != nil || quality < 1 || quality > 100 {
+				return nil, fmt.Errorf("rendition %q has an invalid quality, use 1-100", part)
+			}
+			spec.Quality = quality
+		}
+
+		if seen[spec.Name()] {
+			return nil, fmt.Errorf("rendition %q is listed twice", part)
+		}
+		seen[spec.Name()] = true
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no renditions configured")
+	}
+
+	sortSpecs(specs)
+	return specs, nil
+}
+
+// ParseFallbackFormats reads the comma separated formats every rendition size is also rendered in,
+// e.g. "jpeg" or "avif,jpeg". An empty string means JPEG, "none" turns fallbacks off.
+func ParseFallbackFormats(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []string{FormatJPEG}, nil
+	}
+	if strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+
+	var formats []string
+	for part := range strings.SplitSeq(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		format, err := ParseFormat(part)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %q: %w", part, err)
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// WithFallbacks adds a rendition in every fallback format for each size in specs that does not
+// have one yet, so clients that cannot display WebP still get every srcset width.
+func WithFallbacks(specs []RenditionSpec, formats []string) []RenditionSpec {
+	result := slices.Clone(specs)
+	for _, spec := range specs {
+		for _, format := range formats {
+			exists := slices.ContainsFunc(result, func(s RenditionSpec) bool {
+				return s.LongEdge == spec.LongEdge && s.Format == format
+			})
+			if exists {
+				continue
+			}
+
+			fallback := RenditionSpec{LongEdge: spec.LongEdge, Format: format}
+			if format == FormatJPEG {
+				fallback.Quality = FallbackJPEGQuality
+			}
+			result = append(result, fallback)
+		}
+	}
+
+	sortSpecs(result)
+	return result
+}
+
+// ParseFormat normalises a format name ("jpg" is JPEG) and rejects formats this server cannot render.
+func ParseFormat(value string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(value))
+	if format == "jpg" {
+		format = FormatJPEG
+	}
+
+	switch format {
+	case FormatWebP, FormatJPEG:
+	case FormatAVIF:
+		if !HasEncoder(FormatAVIF) {
+			return "", fmt.Errorf("no AVIF encoder is built into this server")
+		}
+	default:
+		return "", fmt.Errorf("unsupported format %q, use webp, jpeg or avif", format)
+	}
+	return format, nil
+}
+
+func sortSpecs(specs []RenditionSpec) {
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].LongEdge != specs[j].LongEdge {
+			return specs[i].LongEdge < specs[j].LongEdge
+		}
+		return specs[i].Format < specs[j].Format
+	})
+}
+
+// Name identifies the rendition inside its photo, e.g. "640.webp".
+func (s RenditionSpec) Name() string {
+	return fmt.Sprintf("%d.%s", s.LongEdge, s.Extension())
+}
+
+func (s RenditionSpec) Extension() string {
+	if s.Format == FormatJPEG {
+		return "jpg"
+	}
+	return s.Format
+}
+
+// RenditionFileName returns the storage key of a rendition. All renditions of one upload share base.
+func RenditionFileName(base string, spec RenditionSpec) string {
+	return fmt.Sprintf("%s_%d.%s", base, spec.LongEdge, spec.Extension())
+}
+
+// renderRenditions resizes img into every spec. Images are never upscaled: specs larger than the
+// image are rendered at its own size, once per format.
+func renderRenditions(img image.Image, specs []RenditionSpec) ([]RenditionImage, error) {
+	longEdge := max(img.Bounds().Dx(), img.Bounds().Dy())
+
+	var renditions []RenditionImage
+	rendered := make(map[string]bool)
+	for _, spec := range specs {
+		target := min(spec.LongEdge, longEdge)
+		key := fmt.Sprintf("%d.%s", target, spec.Format)
+		if rendered[key] {
+			continue
+		}
+		rendered[key] = true
+
+		var resized image.Image
+		if img.Bounds().Dx() > img.Bounds().Dy() {
+			// landscape resize
+			resized = resize.Resize(uint(target), 0, img, resize.Lanczos3)
+		} else {
+			// portrait resize
+			resized = resize.Resize(0, uint(target), img, resize.Lanczos3)
+		}
+		sharpened := imaging.Sharpen(resized, 0.3)
+
+		data, err := encodeRendition(sharpened, spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s rendition: %w", spec.Name(), err)
+		}
+
+		renditions = append(renditions, RenditionImage{
+			Spec:   RenditionSpec{LongEdge: target, Format: spec.Format, Quality: spec.Quality},
+			Data:   data,
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+		})
+	}
+
+	return renditions, nil
+}
+
+func encodeRendition(img image.Image, spec RenditionSpec) ([]byte, error) {
+	encoder := encoders[spec.Format]
+	if encoder == nil {
+		return nil, fmt.Errorf("no encoder for %s", spec.Format)
+	}
+	return encoder(img, spec.Quality)
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ThumbnailRendition picks the rendition used as the feed thumbnail: a WebP closest to
+// ThumbnailLongEdge, preferring the larger one on a tie.
+func ThumbnailRendition(renditions []RenditionImage) int {
+	best := -1
+	bestScore := 0
+	for i, rendition := range renditions {
+		score := abs(max(rendition.Width, rendition.Height) - ThumbnailLongEdge)
+		if rendition.Spec.Format != FormatWebP {
+			// any WebP beats a JPEG
+			score += 1 << 20
+		}
+		if best == -1 || score < bestScore || (score == bestScore && rendition.Width > renditions[best].Width) {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
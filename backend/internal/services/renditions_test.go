@@ -0,0 +1,97 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRenditionSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []RenditionSpec
+		wantErr bool
+	}{
+		{"empty uses defaults", "  ", DefaultRenditions, false},
+		{"sorted by size", "640:webp, 320:jpg:80", []RenditionSpec{
+			{LongEdge: 320, Format: FormatJPEG, Quality: 80},
+			{LongEdge: 640, Format: FormatWebP},
+		}, false},
+		{"same size in two formats", "640:webp,640:jpeg", []RenditionSpec{
+			{LongEdge: 640, Format: FormatJPEG},
+			{LongEdge: 640, Format: FormatWebP},
+		}, false},
+		{"empty entries are skipped", "320:webp,,", []RenditionSpec{{LongEdge: 320, Format: FormatWebP}}, false},
+		{"quality on webp", "640:webp:80", nil, true},
+		{"missing format", "640", nil, true},
+		{"too many fields", "640:jpeg:80:1", nil, true},
+		{"size too small", "8:webp", nil, true},
+		{"size too large", "9000:webp", nil, true},
+		{"size not a number", "big:webp", nil, true},
+		{"unknown format", "640:gif", nil, true},
+		{"quality too low", "640:jpeg:0", nil, true},
+		{"quality too high", "640:jpeg:101", nil, true},
+		{"listed twice", "640:webp,640:webp", nil, true},
+		{"jpg and jpeg are the same", "640:jpg,640:jpeg:90", nil, true},
+		{"only separators", ",", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRenditionSpecs(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRenditionSpecs(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRenditionSpecs(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+	if !HasEncoder(FormatAVIF) {
+		if _, err := ParseRenditionSpecs("640:avif"); err == nil {
+			t.Error("avif renditions were accepted without an AVIF encoder")
+		}
+	}
+}
+
+func TestThumbnailRendition(t *testing.T) {
+	rendition := func(format string, width, height int) RenditionImage {
+		return RenditionImage{Spec: RenditionSpec{Format: format}, Width: width, Height: height}
+	}
+
+	tests := []struct {
+		name       string
+		renditions []RenditionImage
+		want       int
+	}{
+		{"none", nil, -1},
+		{"closest webp", []RenditionImage{
+			rendition(FormatWebP, 320, 213),
+			rendition(FormatWebP, 640, 427),
+			rendition(FormatWebP, 1280, 853),
+		}, 1},
+		{"portrait uses the long edge", []RenditionImage{
+			rendition(FormatWebP, 213, 320),
+			rendition(FormatWebP, 427, 640),
+		}, 1},
+		{"webp beats a closer jpeg", []RenditionImage{
+			rendition(FormatJPEG, 640, 427),
+			rendition(FormatWebP, 2048, 1365),
+		}, 1},
+		{"jpeg when there is no webp", []RenditionImage{
+			rendition(FormatJPEG, 320, 213),
+			rendition(FormatJPEG, 600, 400),
+		}, 1},
+		{"larger one wins a tie", []RenditionImage{
+			rendition(FormatWebP, 540, 360),
+			rendition(FormatWebP, 740, 493),
+		}, 1},
+		{"small upload", []RenditionImage{rendition(FormatWebP, 50, 70)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ThumbnailRendition(tt.renditions); got != tt.want {
+				t.Errorf("ThumbnailRendition() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
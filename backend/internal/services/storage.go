@@ -0,0 +1,33 @@
+// common interface for every place the processed images can be stored
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidKey is returned when a storage key is empty or escapes the storage root.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage is the blob backend the photo handlers write web images and thumbnails to.
+// Keys are always forward-slash separated, e.g. "thumbnails/2025/01/02/<uuid>.webp".
+type Storage interface {
+	// UploadFile stores data under key and returns the public URL of the object.
+	UploadFile(ctx context.Context, key string, data []byte) (string, error)
+	// DeleteFile removes the object stored under key.
+	DeleteFile(ctx context.Context, key string) error
+	// FileExists reports whether an object is stored under key.
+	FileExists(ctx context.Context, key string) (bool, error)
+	// PublicURL returns the URL the object stored under key is served from.
+	PublicURL(key string) string
+	// ListFiles returns every object whose key starts with prefix.
+	ListFiles(ctx context.Context, prefix string) ([]StoredFile, error)
+}
+
+// StoredFile describes an object returned by Storage.ListFiles.
+type StoredFile struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
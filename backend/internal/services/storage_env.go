@@ -0,0 +1,77 @@
+// build the storage backend selected by the environment, shared by the API and the CLI tools
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// NewStorageFromEnv picks the blob backend from STORAGE_DRIVER ("r2" by default, "s3" or "local").
+func NewStorageFromEnv() (Storage, error) {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+
+	switch driver {
+	case "", "r2":
+		log.Println("[STORAGE] Using Cloudflare R2 storage")
+		return NewR2Service(
+			os.Getenv("R2_ACCOUNT_ID"),
+			os.Getenv("R2_ACCESS_KEY_ID"),
+			os.Getenv("R2_SECRET_ACCESS_KEY"),
+			os.Getenv("R2_BUCKET_PUBLIC_URL"),
+			os.Getenv("R2_BUCKET_NAME"),
+		)
+	case "s3":
+		log.Printf("[STORAGE] Using S3-compatible storage at %s", os.Getenv("S3_ENDPOINT"))
+		return NewS3Service(S3Config{
+			Endpoint:           os.Getenv("S3_ENDPOINT"),
+			Region:             os.Getenv("S3_REGION"),
+			AccessKey:          os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey:          os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Bucket:             os.Getenv("S3_BUCKET_NAME"),
+			PublicURL:          os.Getenv("S3_BUCKET_PUBLIC_URL"),
+			UsePathStyle:       envBool("S3_FORCE_PATH_STYLE"),
+			InsecureSkipVerify: envBool("S3_INSECURE_SKIP_VERIFY"),
+		})
+	case "local":
+		dir := os.Getenv("LOCAL_STORAGE_DIR")
+		if dir == "" {
+			dir = "./media"
+		}
+
+		publicURL := os.Getenv("LOCAL_STORAGE_PUBLIC_URL")
+		if publicURL == "" {
+			publicURL = LocalMountPath()
+		}
+
+		localStorage, err := NewLocalStorage(dir, publicURL)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("[STORAGE] Using local storage at %s", localStorage.RootDir)
+		return localStorage, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+// LocalMountPath is the route the API serves local storage files under (LOCAL_STORAGE_MOUNT_PATH, default /media).
+func LocalMountPath() string {
+	mountPath := os.Getenv("LOCAL_STORAGE_MOUNT_PATH")
+	if mountPath == "" {
+		mountPath = "/media"
+	}
+	return "/" + strings.Trim(mountPath, "/")
+}
+
+// envBool treats "1", "true" and "yes" (any case) as true.
+func envBool(key string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,144 @@
+// the rules an upload has to pass before it is decoded
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Error codes returned with a ValidationError, stable for the frontend to switch on.
+const (
+	CodeFileTooLarge     = "file_too_large"
+	CodeUnsupportedType  = "unsupported_type"
+	CodeUnreadableImage  = "unreadable_image"
+	CodeImageTooSmall    = "image_too_small"
+	CodeImageTooLarge    = "image_too_large"
+	CodeTooManyPixels    = "too_many_pixels"
+	CodeAspectRatioLimit = "aspect_ratio"
+)
+
+// DecodableTypes are the MIME types the server can decode, AllowedTypes may only narrow them.
+var DecodableTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+// ValidationError is an upload that breaks the UploadPolicy. Status is the HTTP status to answer
+// with: 413 for size, 415 for type and 422 for an image that cannot be used.
+type ValidationError struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// UploadPolicy bounds what the upload endpoint accepts. Zero values turn a rule off, except
+// AllowedTypes, which falls back to DecodableTypes when empty.
+type UploadPolicy struct {
+	MaxBytes int64
+	// MinDimension applies to the shorter side, MaxDimension to the longer one, so portrait
+	// and landscape shots are treated alike
+	MinDimension int
+	MaxDimension int
+	MaxPixels    int64
+	// MaxAspectRatio is the longest the long side may be relative to the short one, e.g. 4 for 4:1
+	MaxAspectRatio float64
+	AllowedTypes   []string
+}
+
+// DefaultUploadPolicy keeps the limits the upload endpoint always had: 20 MB and 8000x8000 pixels.
+var DefaultUploadPolicy = UploadPolicy{
+	MaxBytes:  20 << 20,
+	MaxPixels: 8000 * 8000,
+}
+
+// CheckSize rejects files larger than MaxBytes.
+func (p UploadPolicy) CheckSize(size int64) error {
+	if p.MaxBytes > 0 && size > p.MaxBytes {
+		return p.FileTooLarge()
+	}
+	return nil
+}
+
+// FileTooLarge is the error for a file over MaxBytes, also used when the request body is cut off.
+func (p UploadPolicy) FileTooLarge() error {
+	return &ValidationError{
+		Code:    CodeFileTooLarge,
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("file is larger than %s", formatBytes(p.MaxBytes)),
+	}
+}
+
+// CheckType rejects MIME types outside AllowedTypes. contentType is sniffed from the file, not
+// taken from the client.
+func (p UploadPolicy) CheckType(contentType string) error {
+	allowed := p.AllowedTypes
+	if len(allowed) == 0 {
+		allowed = DecodableTypes
+	}
+	if !slices.Contains(allowed, contentType) {
+		return &ValidationError{
+			Code:    CodeUnsupportedType,
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("unsupported file type %s, allowed are %s", contentType, strings.Join(allowed, ", ")),
+		}
+	}
+	return nil
+}
+
+// CheckDimensions applies the pixel rules to the size read from the image header.
+func (p UploadPolicy) CheckDimensions(width, height int) error {
+	short, long := min(width, height), max(width, height)
+
+	switch {
+	case short <= 0:
+		return unprocessable(CodeUnreadableImage, "image has no pixels")
+	case p.MinDimension > 0 && short < p.MinDimension:
+		return unprocessable(CodeImageTooSmall, fmt.Sprintf("image is %dx%d, the shorter side must be at least %dpx", width, height, p.MinDimension))
+	case p.MaxDimension > 0 && long > p.MaxDimension:
+		return unprocessable(CodeImageTooLarge, fmt.Sprintf("image is %dx%d, the longer side may be at most %dpx", width, height, p.MaxDimension))
+	case p.MaxPixels > 0 && int64(width)*int64(height) > p.MaxPixels:
+		return unprocessable(CodeTooManyPixels, fmt.Sprintf("image is %dx%d, at most %.1f megapixels are allowed", width, height, float64(p.MaxPixels)/1e6))
+	case p.MaxAspectRatio > 0 && float64(long)/float64(short) > p.MaxAspectRatio:
+		return unprocessable(CodeAspectRatioLimit, fmt.Sprintf("image is %dx%d, the aspect ratio may be at most %g:1", width, height, p.MaxAspectRatio))
+	}
+	return nil
+}
+
+// UnreadableImage is returned when the image header or pixels cannot be decoded.
+func UnreadableImage(err error) error {
+	return unprocessable(CodeUnreadableImage, fmt.Sprintf("image could not be read: %v", err))
+}
+
+// ParseAllowedTypes reads a comma separated list of MIME types, e.g. "image/jpeg,image/png".
+// An empty string allows every type in DecodableTypes.
+func ParseAllowedTypes(value string) ([]string, error) {
+	var types []string
+	for part := range strings.SplitSeq(value, ",") {
+		contentType := strings.ToLower(strings.TrimSpace(part))
+		if contentType == "" {
+			continue
+		}
+		if contentType == "image/jpg" {
+			contentType = "image/jpeg"
+		}
+		if !slices.Contains(DecodableTypes, contentType) {
+			return nil, fmt.Errorf("%s cannot be decoded, use %s", contentType, strings.Join(DecodableTypes, ", "))
+		}
+		types = append(types, contentType)
+	}
+	return types, nil
+}
+
+func unprocessable(code, message string) error {
+	return &ValidationError{Code: code, Status: http.StatusUnprocessableEntity, Message: message}
+}
+
+func formatBytes(n int64) string {
+	if n%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCheckDimensions(t *testing.T) {
+	policy := UploadPolicy{MinDimension: 100, MaxDimension: 6000, MaxPixels: 24_000_000, MaxAspectRatio: 4}
+
+	tests := []struct {
+		name          string
+		policy        UploadPolicy
+		width, height int
+		wantCode      string
+	}{
+		{"within every limit", policy, 4000, 3000, ""},
+		{"portrait within every limit", policy, 3000, 4000, ""},
+		{"exactly at the limits", policy, 6000, 4000, ""},
+		{"no pixels", policy, 0, 300, CodeUnreadableImage},
+		{"negative size", policy, -1, 300, CodeUnreadableImage},
+		{"shorter side too small", policy, 4000, 99, CodeImageTooSmall},
+		{"portrait shorter side too small", policy, 99, 300, CodeImageTooSmall},
+		{"longer side too large", policy, 6001, 3000, CodeImageTooLarge},
+		{"portrait longer side too large", policy, 3000, 6001, CodeImageTooLarge},
+		{"too many pixels", UploadPolicy{MaxPixels: 1_000_000}, 1001, 1000, CodeTooManyPixels},
+		{"pixel count does not overflow", UploadPolicy{MaxPixels: 1 << 40}, 1 << 30, 1 << 30, CodeTooManyPixels},
+		{"panorama", policy, 4000, 999, CodeAspectRatioLimit},
+		{"aspect ratio at the limit", policy, 4000, 1000, ""},
+		{"zero limits are off", UploadPolicy{}, 100000, 1, ""},
+		{"defaults allow 8000x8000", DefaultUploadPolicy, 8000, 8000, ""},
+		{"defaults reject more", DefaultUploadPolicy, 8001, 8000, CodeTooManyPixels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckDimensions(tt.width, tt.height)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("CheckDimensions(%d, %d) = %v, want nil", tt.width, tt.height, err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("CheckDimensions(%d, %d) = %v, want a ValidationError", tt.width, tt.height, err)
+			}
+			if validationErr.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", validationErr.Code, tt.wantCode)
+			}
+			if validationErr.Status != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want 422", validationErr.Status)
+			}
+		})
+	}
+}
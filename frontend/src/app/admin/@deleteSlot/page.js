@@ -69,7 +69,7 @@ export default function DeleteSlotPage() {
         const data = await res.json()
         toast.success("Successfully deleted selected photos", {
             position: "top-right",
-            description: `Total Photos Deleted: ${data.db_deleted}`
+            description: `Photos Moved to Trash: ${data.trashed}`
         })
 
         setDeleting(false)
@@ -100,7 +100,7 @@ export default function DeleteSlotPage() {
         const data = await res.json()
         toast.success("Successfully deleted ALL photos", {
             position: "top-right",
-            description: `Total Photos Deleted: ${data.db_deleted}`
+            description: `Photos Moved to Trash: ${data.trashed}`
         })
 
         setDeleting(false)
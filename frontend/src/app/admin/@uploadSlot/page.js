@@ -128,8 +128,7 @@ export default function AdminUploadSlot() {
         fd.append("exif", JSON.stringify({
             shutterSpeed: tagsExif.ShutterSpeedValue?.description,
             aperture: tagsExif.FNumber?.description,
-            iso: tagsExif.ISOSpeedRatings?.description.toString(),
-            imageOrientation: tagsExif.Orientation?.value
+            iso: tagsExif.ISOSpeedRatings?.description.toString()
         }))
 
         const res = await fetch(